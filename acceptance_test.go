@@ -47,6 +47,40 @@ func TestAcceptance(t *testing.T) {
 			},
 			WriteTimeout: 500 * time.Millisecond,
 			ReadTimeout:  500 * time.Millisecond,
+			Skip:         skipAcceptanceTests,
+		},
+	}
+
+	sdk.AcceptanceTest(t, driver)
+}
+
+func TestAcceptanceTransactional(t *testing.T) {
+	sourceCfg := map[string]string{
+		"url":      "localhost:61613",
+		"user":     "admin",
+		"password": "admin",
+	}
+	destCfg := map[string]string{
+		"url":             "localhost:61613",
+		"user":            "admin",
+		"password":        "admin",
+		"transactional":   "true",
+		"receipt.enabled": "true",
+	}
+
+	driver := sdk.ConfigurableAcceptanceTestDriver{
+		Config: sdk.ConfigurableAcceptanceTestDriverConfig{
+			Connector:         Connector,
+			SourceConfig:      sourceCfg,
+			DestinationConfig: destCfg,
+			BeforeTest: func(t *testing.T) {
+				queueName := uniqueQueueName(t)
+				sourceCfg["queue"] = queueName
+				destCfg["queue"] = queueName
+			},
+			WriteTimeout: 500 * time.Millisecond,
+			ReadTimeout:  500 * time.Millisecond,
+			Skip:         skipAcceptanceTests,
 		},
 	}
 
@@ -88,12 +122,18 @@ func TestAcceptanceTLS(t *testing.T) {
 			},
 			WriteTimeout: 500 * time.Millisecond,
 			ReadTimeout:  500 * time.Millisecond,
+			Skip:         skipAcceptanceTests,
 		},
 	}
 
 	sdk.AcceptanceTest(t, driver)
 }
 
+// skipAcceptanceTests are replaced by tests of this connector. The SDK doesn't
+// allow wildcard destination parameters, which connectHeaders.* is, so
+// TestDestinationParameters checks the destination parameters instead.
+var skipAcceptanceTests = []string{"TestDestination_Parameters_Success"}
+
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 func randomString() string {
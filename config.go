@@ -15,21 +15,41 @@
 package activemq
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
 )
 
 type Config struct {
-	// The URL of the ActiveMQ classic broker.
+	// The URL of the ActiveMQ classic broker. Either host:port, a broker URI
+	// such as tcp://host:port or ssl://host:port, a STOMP over WebSocket URI
+	// such as ws://host:port/path or wss://host:port/path, or a failover URI
+	// such as failover:(tcp://host1:61613,tcp://host2:61613)?randomize=false.
 	URL string `json:"url" validate:"required"`
 
-	// The username to use when connecting to the broker.
-	User string `json:"user" validate:"required"`
+	// The username to use when connecting to the broker. Leave empty to
+	// connect to brokers that allow anonymous access.
+	User string `json:"user"`
 
 	// The password to use when connecting to the broker.
-	Password string `json:"password" validate:"required"`
+	Password string `json:"password"`
+
+	// The path to a file containing the password to use when connecting to the
+	// broker. The file is read again before each reconnect, so that a rotated
+	// password is picked up. Leading and trailing whitespace is trimmed.
+	PasswordFile string `json:"passwordFile"`
 
-	// The name of the queue to write to.
-	Queue string `json:"queue" validate:"required"`
+	// The name of an environment variable containing the password to use when
+	// connecting to the broker.
+	PasswordEnv string `json:"passwordEnv"`
 
 	// The maximum amount of time between the client sending heartbeat notifications to the server
 	SendTimeoutHeartbeat time.Duration `json:"sendTimeoutHeartbeat" default:"2s"`
@@ -37,22 +57,187 @@ type Config struct {
 	// The minimum amount of time between the client expecting to receive heartbeat notifications from the server
 	RecvTimeoutHeartbeat time.Duration `json:"recvTimeoutHeartbeat" default:"2s"`
 
+	// The virtual host to connect to, sent in the host header of the CONNECT
+	// frame. Defaults to the address of the broker.
+	VirtualHost string `json:"virtualHost"`
+
+	// Additional headers to send in the CONNECT frame, e.g. for broker plugins
+	// or authentication interceptors, e.g. connectHeaders.x-tenant: acme. The
+	// headers login, passcode, host, accept-version, heart-beat and client-id
+	// are set by the connector and can't be used.
+	ConnectHeaders map[string]string `json:"connectHeaders"`
+
+	// The STOMP protocol versions to accept, separated by commas. Any of 1.0,
+	// 1.1 and 1.2. Defaults to all of them. Negative acknowledgements, used by
+	// the source options ackTimeout and payload.parseErrorPolicy nack, require
+	// 1.1 or later.
+	AcceptVersions []string `json:"acceptVersions"`
+
 	TLS TLSConfig `json:"tls"`
+
+	Reconnect ReconnectConfig `json:"reconnect"`
+}
+
+// Validate validates the configuration shared by the source and destination.
+func (c Config) Validate(context.Context) error {
+	var errs []error
+	if _, err := parseBrokerURL(c.URL, c.Reconnect); err != nil {
+		errs = append(errs, fmt.Errorf("invalid url: %w", err))
+	}
+
+	if _, err := c.connectHeaders(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.stompVersions(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tls config: %w", err))
+	}
+
+	passwordSources := 0
+	for _, v := range []string{c.Password, c.PasswordFile, c.PasswordEnv} {
+		if v != "" {
+			passwordSources++
+		}
+	}
+	if passwordSources > 1 {
+		errs = append(errs, errors.New("only one of password, passwordFile and passwordEnv can be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// reservedConnectHeaders are the CONNECT headers set by the connector.
+var reservedConnectHeaders = []string{
+	frame.Login, frame.Passcode, frame.Host, frame.AcceptVersion, frame.HeartBeat, "client-id",
+}
+
+// connectHeaders returns the additional CONNECT headers, sorted by name so
+// that the CONNECT frame is deterministic.
+func (c Config) connectHeaders() (*frame.Header, error) {
+	headers := frame.NewHeader()
+	for _, name := range slices.Sorted(maps.Keys(c.ConnectHeaders)) {
+		if slices.Contains(reservedConnectHeaders, name) {
+			return nil, fmt.Errorf("connectHeaders can't contain the %v header", name)
+		}
+		headers.Add(name, c.ConnectHeaders[name])
+	}
+
+	return headers, nil
+}
+
+// stompVersions returns the accepted STOMP versions, or nil if all versions
+// supported by the client are accepted.
+func (c Config) stompVersions() ([]stomp.Version, error) {
+	var versions []stomp.Version
+	for _, v := range c.AcceptVersions {
+		version := stomp.Version(v)
+		if err := version.CheckSupported(); err != nil {
+			return nil, fmt.Errorf("invalid acceptVersions: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// supportsNack reports whether the accepted STOMP versions include one that
+// supports negative acknowledgements.
+func (c Config) supportsNack() bool {
+	if len(c.AcceptVersions) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(c.AcceptVersions, func(v string) bool {
+		return stomp.Version(v).SupportsNack()
+	})
+}
+
+// password returns the configured password, reading it from the password file
+// or environment variable if configured.
+func (c Config) password() (string, error) {
+	switch {
+	case c.PasswordFile != "":
+		password, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimSpace(string(password)), nil
+	case c.PasswordEnv != "":
+		password, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q with the password is not set", c.PasswordEnv)
+		}
+		return password, nil
+	default:
+		return c.Password, nil
+	}
+}
+
+type ReconnectConfig struct {
+	// Flag to enable or disable reconnecting to the broker when the connection is lost.
+	Enabled bool `json:"enabled" default:"true"`
+
+	// The maximum number of consecutive reconnection attempts before giving up. 0 means no limit.
+	MaxAttempts int `json:"maxAttempts" default:"0" validate:"gt=-1"`
+
+	// The amount of time to wait before the first reconnection attempt.
+	InitialBackoff time.Duration `json:"initialBackoff" default:"100ms"`
+
+	// The maximum amount of time to wait between reconnection attempts.
+	MaxBackoff time.Duration `json:"maxBackoff" default:"30s"`
+
+	// The factor by which the backoff grows after each failed reconnection attempt.
+	BackoffFactor float64 `json:"backoffFactor" default:"2"`
+
+	// Flag to randomize the backoff between reconnection attempts.
+	Jitter bool `json:"jitter" default:"true"`
 }
 
 type TLSConfig struct {
 	// Flag to enable or disable TLS.
 	Enabled bool `json:"enabled" default:"false"`
 
-	// The path to the client key file.
+	// The path to the client key file. Client certificates are optional.
 	ClientKeyPath string `json:"clientKeyPath"`
 
-	// The path to the client certificate file.
+	// The path to the client certificate file. Client certificates are optional.
 	ClientCertPath string `json:"clientCertPath"`
 
-	// The path to the CA certificate file.
+	// The path to a PKCS#12 keystore containing the client certificate and
+	// key, as an alternative to clientCertPath and clientKeyPath. Java
+	// keystores can be converted with keytool -importkeystore -deststoretype PKCS12.
+	KeystorePath string `json:"keystorePath"`
+
+	// The password of the PKCS#12 keystore.
+	KeystorePassword string `json:"keystorePassword"`
+
+	// The path to the CA certificate file in PEM format. If neither this nor
+	// truststorePath is set, the system root certificates are used.
 	CaCertPath string `json:"caCertPath"`
 
+	// The path to a PKCS#12 truststore containing the trusted CA certificates,
+	// as an alternative to caCertPath.
+	TruststorePath string `json:"truststorePath"`
+
+	// The password of the PKCS#12 truststore.
+	TruststorePassword string `json:"truststorePassword"`
+
+	// The server name used to verify the broker's certificate and sent with
+	// SNI. Defaults to the host of the broker.
+	ServerName string `json:"serverName"`
+
+	// The minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3.
+	MinVersion string `json:"minVersion" default:"1.2" validate:"inclusion=1.0|1.1|1.2|1.3"`
+
+	// The names of the cipher suites to use for TLS 1.2 and lower, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Defaults to Go's default
+	// cipher suites. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string `json:"cipherSuites"`
+
 	// Flag to skip verification of the server's certificate chain and host name.
 	InsecureSkipVerify bool `json:"insecureSkipVerify" default:"false"`
 }
@@ -0,0 +1,116 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/matryer/is"
+)
+
+func TestConfigPassword(t *testing.T) {
+	is := is.New(t)
+
+	password, err := Config{Password: "secret"}.password()
+	is.NoErr(err)
+	is.Equal(password, "secret")
+
+	// The password file is read on every call, so rotated passwords are used.
+	path := filepath.Join(t.TempDir(), "password")
+	is.NoErr(os.WriteFile(path, []byte("first\n"), 0o600))
+	config := Config{PasswordFile: path}
+
+	password, err = config.password()
+	is.NoErr(err)
+	is.Equal(password, "first")
+
+	is.NoErr(os.WriteFile(path, []byte("second\n"), 0o600))
+	password, err = config.password()
+	is.NoErr(err)
+	is.Equal(password, "second")
+
+	t.Setenv("ACTIVEMQ_TEST_PASSWORD", "from-env")
+	password, err = Config{PasswordEnv: "ACTIVEMQ_TEST_PASSWORD"}.password()
+	is.NoErr(err)
+	is.Equal(password, "from-env")
+
+	_, err = Config{PasswordEnv: "ACTIVEMQ_TEST_PASSWORD_UNSET"}.password()
+	is.True(err != nil)
+}
+
+func TestConfigValidatePasswordSources(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	// Anonymous access doesn't need credentials.
+	is.NoErr(Config{URL: "localhost:61613"}.Validate(ctx))
+
+	config := Config{URL: "localhost:61613", Password: "secret", PasswordEnv: "PASSWORD"}
+	is.True(config.Validate(ctx) != nil)
+}
+
+func TestConfigValidateConnectHeaders(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := Config{
+		URL:            "localhost:61613",
+		VirtualHost:    "broker-a",
+		ConnectHeaders: map[string]string{"x-tenant": "acme", "x-region": "eu,us"},
+		AcceptVersions: []string{"1.1", "1.2"},
+	}
+	is.NoErr(config.Validate(ctx))
+
+	versions, err := config.stompVersions()
+	is.NoErr(err)
+	is.Equal(versions, []stomp.Version{stomp.V11, stomp.V12})
+
+	// Headers are sorted by name, values can contain commas.
+	headers, err := config.connectHeaders()
+	is.NoErr(err)
+	is.Equal(headers.Len(), 2)
+	name, value := headers.GetAt(0)
+	is.Equal(name, "x-region")
+	is.Equal(value, "eu,us")
+	name, value = headers.GetAt(1)
+	is.Equal(name, "x-tenant")
+	is.Equal(value, "acme")
+
+	config.ConnectHeaders = map[string]string{"passcode": "secret"}
+	is.True(config.Validate(ctx) != nil)
+
+	config.ConnectHeaders = nil
+	config.AcceptVersions = []string{"2.0"}
+	is.True(config.Validate(ctx) != nil)
+}
+
+func TestConfigParseConnectHeaders(t *testing.T) {
+	is := is.New(t)
+
+	var config DestinationConfig
+	err := sdk.Util.ParseConfig(context.Background(), map[string]string{
+		"url":                     "localhost:61613",
+		"queue":                   "orders",
+		"connectHeaders.x-tenant": "acme",
+		"connectHeaders.x-region": "eu,us",
+	}, &config, Connector.NewSpecification().DestinationParams)
+	is.NoErr(err)
+	is.Equal(config.ConnectHeaders, map[string]string{"x-tenant": "acme", "x-region": "eu,us"})
+}
@@ -0,0 +1,232 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/jpillora/backoff"
+)
+
+// connManager owns the STOMP connection to the broker. When the connection
+// breaks, it fails over to the next broker, dialing with exponential backoff,
+// and runs onConnect again so that the source can re-subscribe.
+type connManager struct {
+	config    ReconnectConfig
+	brokers   []brokerAddr
+	dial      func(context.Context, brokerAddr) (*stomp.Conn, error)
+	onConnect func(context.Context, *stomp.Conn) error
+
+	// reconnectMu makes sure only one reconnect runs at a time.
+	reconnectMu sync.Mutex
+
+	// mu guards the fields below. It is not held while backing off or
+	// dialing, so that Conn and Close don't block during a reconnect.
+	mu   sync.Mutex
+	conn *stomp.Conn
+	// current is the index of the broker that was dialed last.
+	current int
+	// closed is closed by Close to stop a running reconnect.
+	closed chan struct{}
+}
+
+func newConnManager(
+	url brokerURL,
+	dial func(context.Context, brokerAddr) (*stomp.Conn, error),
+	onConnect func(context.Context, *stomp.Conn) error,
+) *connManager {
+	return &connManager{
+		config:    url.reconnect,
+		brokers:   url.brokers,
+		dial:      dial,
+		onConnect: onConnect,
+		closed:    make(chan struct{}),
+	}
+}
+
+// Open dials the initial connection, trying each broker once in order. It
+// doesn't back off, so that a misconfigured connector fails right away.
+func (m *connManager) Open(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for i := range m.brokers {
+		m.current = i
+		conn, err := m.connect(ctx, i)
+		if err == nil {
+			m.conn = conn
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Conn returns the current connection. It returns nil while reconnecting.
+func (m *connManager) Conn() *stomp.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.conn
+}
+
+// Reconnect drops the current connection and dials a new one, waiting with
+// backoff between failed attempts. It returns an error if reconnection is
+// disabled, the attempts are exhausted or the context is cancelled.
+func (m *connManager) Reconnect(ctx context.Context) (*stomp.Conn, error) {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
+
+	if !m.config.Enabled {
+		return nil, errors.New("reconnection is disabled")
+	}
+
+	m.mu.Lock()
+	broken, current := m.conn, m.current
+	m.conn = nil
+	m.mu.Unlock()
+	if broken != nil {
+		// The connection is already broken, we only want to release its resources.
+		_ = broken.MustDisconnect()
+	}
+
+	b := &backoff.Backoff{
+		Min:    m.config.InitialBackoff,
+		Max:    m.config.MaxBackoff,
+		Factor: m.config.BackoffFactor,
+		Jitter: m.config.Jitter,
+	}
+
+	for {
+		wait := b.Duration()
+		attempt := int(b.Attempt())
+		current = (current + 1) % len(m.brokers)
+
+		sdk.Logger(ctx).Info().
+			Int("attempt", attempt).
+			Dur("backoff", wait).
+			Stringer("broker", m.brokers[current]).
+			Msg("reconnecting to ActiveMQ")
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("context error while reconnecting: %w", ctx.Err())
+		case <-m.closed:
+			return nil, errConnManagerClosed
+		case <-time.After(wait):
+		}
+
+		conn, err := m.connect(ctx, current)
+
+		m.mu.Lock()
+		m.current = current
+		select {
+		case <-m.closed:
+			// Close was called while dialing, the new connection is not used.
+			m.mu.Unlock()
+			if conn != nil {
+				_ = conn.MustDisconnect()
+			}
+			return nil, errConnManagerClosed
+		default:
+		}
+		if err == nil {
+			m.conn = conn
+		}
+		m.mu.Unlock()
+
+		if err == nil {
+			sdk.Logger(ctx).Info().
+				Int("attempt", attempt).
+				Stringer("broker", m.brokers[current]).
+				Msg("reconnected to ActiveMQ")
+			return conn, nil
+		}
+
+		if m.config.MaxAttempts > 0 && attempt >= m.config.MaxAttempts {
+			return nil, fmt.Errorf("failed to reconnect after %d attempts: %w", attempt, err)
+		}
+		sdk.Logger(ctx).Warn().Err(err).Int("attempt", attempt).Msg("failed to reconnect to ActiveMQ")
+	}
+}
+
+// Close disconnects the current connection, if any, and stops a running
+// reconnect.
+func (m *connManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+
+	if m.conn == nil {
+		return nil
+	}
+
+	err := m.conn.Disconnect()
+	m.conn = nil
+	if err != nil && !errors.Is(err, stomp.ErrAlreadyClosed) {
+		return fmt.Errorf("failed to disconnect from ActiveMQ: %w", err)
+	}
+
+	return nil
+}
+
+// connect dials the broker with the given index and runs onConnect on the new
+// connection.
+func (m *connManager) connect(ctx context.Context, current int) (*stomp.Conn, error) {
+	broker := m.brokers[current]
+	conn, err := m.dial(ctx, broker)
+	if err != nil {
+		return nil, fmt.Errorf("broker %v: %w", broker, err)
+	}
+
+	if m.onConnect != nil {
+		if err := m.onConnect(ctx, conn); err != nil {
+			_ = conn.MustDisconnect()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// errConnManagerClosed is returned by Reconnect when the connection manager was
+// closed.
+var errConnManagerClosed = errors.New("connection manager closed")
+
+// isConnectionError reports whether err means that the connection to the
+// broker is unusable and needs to be replaced.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, stomp.ErrAlreadyClosed) ||
+		errors.Is(err, stomp.ErrClosedUnexpectedly) ||
+		errors.Is(err, stomp.ErrMsgSendTimeout) ||
+		errors.Is(err, io.EOF) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,135 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/matryer/is"
+)
+
+func TestConnManagerReconnectMaxAttempts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	dialErr := errors.New("connection refused")
+	var dialed []string
+	m := newConnManager(brokerURL{
+		brokers: []brokerAddr{{host: "a:61613"}, {host: "b:61613"}},
+		reconnect: ReconnectConfig{
+			Enabled:        true,
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+			BackoffFactor:  2,
+		},
+	}, func(_ context.Context, broker brokerAddr) (*stomp.Conn, error) {
+		dialed = append(dialed, broker.host)
+		return nil, dialErr
+	}, nil)
+
+	_, err := m.Reconnect(ctx)
+	is.True(errors.Is(err, dialErr))
+	is.Equal(dialed, []string{"b:61613", "a:61613", "b:61613"})
+	is.Equal(m.Conn(), nil)
+}
+
+func TestConnManagerOpenTriesAllBrokers(t *testing.T) {
+	is := is.New(t)
+
+	var dialed []string
+	m := newConnManager(brokerURL{
+		brokers:   []brokerAddr{{host: "a:61613"}, {host: "b:61613"}},
+		reconnect: ReconnectConfig{Enabled: true},
+	}, func(_ context.Context, broker brokerAddr) (*stomp.Conn, error) {
+		dialed = append(dialed, broker.host)
+		return nil, errors.New("connection refused")
+	}, nil)
+
+	err := m.Open(context.Background())
+	is.True(err != nil)
+	is.Equal(dialed, []string{"a:61613", "b:61613"})
+}
+
+func TestConnManagerReconnectDisabled(t *testing.T) {
+	is := is.New(t)
+
+	m := newConnManager(brokerURL{
+		brokers:   []brokerAddr{{host: "a:61613"}},
+		reconnect: ReconnectConfig{Enabled: false},
+	}, func(context.Context, brokerAddr) (*stomp.Conn, error) {
+		t.Fatal("unexpected dial")
+		return nil, nil
+	}, nil)
+
+	_, err := m.Reconnect(context.Background())
+	is.True(err != nil)
+}
+
+func TestConnManagerReconnectContextCancelled(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := newConnManager(brokerURL{
+		brokers: []brokerAddr{{host: "a:61613"}},
+		reconnect: ReconnectConfig{
+			Enabled:        true,
+			InitialBackoff: time.Hour,
+			MaxBackoff:     time.Hour,
+			BackoffFactor:  2,
+		},
+	}, func(context.Context, brokerAddr) (*stomp.Conn, error) {
+		t.Fatal("unexpected dial")
+		return nil, nil
+	}, nil)
+
+	_, err := m.Reconnect(ctx)
+	is.True(errors.Is(err, context.Canceled))
+}
+
+func TestConnManagerReconnectDoesNotBlock(t *testing.T) {
+	is := is.New(t)
+
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	m := newConnManager(brokerURL{
+		brokers:   []brokerAddr{{host: "a:61613"}},
+		reconnect: ReconnectConfig{Enabled: true, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}, func(context.Context, brokerAddr) (*stomp.Conn, error) {
+		close(dialing)
+		<-release
+		return nil, errors.New("connection refused")
+	}, nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := m.Reconnect(context.Background())
+		errs <- err
+	}()
+	<-dialing
+
+	// The connection and Close are available while the dial is in progress.
+	is.Equal(m.Conn(), nil)
+	is.NoErr(m.Close())
+	close(release)
+
+	// Closing stops the reconnect.
+	is.True(errors.Is(<-errs, errConnManagerClosed))
+}
@@ -16,8 +16,13 @@ package activemq
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"text/template"
+	"time"
 
+	"github.com/Masterminds/sprig/v3"
 	"github.com/conduitio/conduit-commons/opencdc"
 	sdk "github.com/conduitio/conduit-connector-sdk"
 	"github.com/go-stomp/stomp/v3"
@@ -27,13 +32,175 @@ type DestinationConfig struct {
 	sdk.DefaultDestinationMiddleware
 
 	Config
+
+	// The name of the queue to write to. When queueTemplate or queueMetadataKey
+	// is set, this queue is only used for records for which they don't produce
+	// a queue name.
+	Queue string `json:"queue"`
+
+	// A Go template that is evaluated for each record to get the name of the
+	// queue to write the record to, e.g. {{ index .Metadata "opencdc.collection" }}.
+	// Sprig functions are available in the template.
+	QueueTemplate string `json:"queueTemplate"`
+
+	// The metadata key that holds the name of the queue to write each record to.
+	QueueMetadataKey string `json:"queueMetadataKey"`
+
+	// The part of the record that is sent as the message body, one of record,
+	// payloadAfter, key or template. The record mode sends the whole record
+	// formatted according to sdk.record.format.
+	PayloadMode string `json:"payload.mode" default:"record" validate:"inclusion=record|payloadAfter|key|template"`
+
+	// The Go template used to build the message body when payload.mode is template.
+	// Sprig functions are available in the template.
+	PayloadTemplate string `json:"payload.template"`
+
+	// The content-type header of the sent messages. By default, it's derived
+	// from payload.mode: application/json for records and structured data,
+	// application/octet-stream for raw data and text/plain for templates.
+	ContentType string `json:"payload.contentType"`
+
+	Headers HeadersConfig `json:"headers"`
+
+	Receipt ReceiptConfig `json:"receipt"`
+
+	// Flag to write each batch of records in a single broker transaction. If
+	// sending any record fails, the transaction is aborted and none of the
+	// records in the batch are written. Use sdk.batch.size to control how many
+	// records are written in one transaction.
+	Transactional bool `json:"transactional" default:"false"`
+
+	Delivery DeliveryConfig `json:"delivery"`
+
+	// The number of connections used to send messages. The records of a batch
+	// are spread across the connections and sent concurrently. Records with the
+	// same JMSXGroupID header, or else the same key, are always sent on the
+	// same connection, in order. Can't be used with transactional.
+	Connections int `json:"connections" default:"1" validate:"gt=0"`
+}
+
+// DeliveryConfig holds the delivery options of sent messages. Every option can
+// be set statically or per record, from the value of a metadata key. A value
+// found in the metadata takes precedence over the static value.
+type DeliveryConfig struct {
+	// Whether the broker should persist sent messages. Maps to the persistent header.
+	Persistent bool `json:"persistent" default:"false"`
+
+	// The metadata key that holds whether the broker should persist a message.
+	PersistentMetadataKey string `json:"persistentMetadataKey"`
+
+	// The priority of sent messages, from 1 to 9. 0 leaves the priority to the broker default.
+	// Maps to the priority header.
+	Priority int `json:"priority" default:"0" validate:"gt=-1,lt=10"`
+
+	// The metadata key that holds the priority of a message.
+	PriorityMetadataKey string `json:"priorityMetadataKey"`
+
+	// The amount of time after which sent messages expire. 0 means messages never expire.
+	// Maps to the expires header.
+	TimeToLive time.Duration `json:"timeToLive" default:"0"`
+
+	// The metadata key that holds the time to live of a message, either as a
+	// duration (e.g. 10s) or in milliseconds.
+	TimeToLiveMetadataKey string `json:"timeToLiveMetadataKey"`
+
+	// The amount of time the broker waits before delivering sent messages.
+	// Requires the broker scheduler to be enabled. Maps to the AMQ_SCHEDULED_DELAY header.
+	ScheduledDelay time.Duration `json:"scheduledDelay" default:"0"`
+
+	// The metadata key that holds the scheduled delay of a message, either as a
+	// duration (e.g. 10s) or in milliseconds.
+	ScheduledDelayMetadataKey string `json:"scheduledDelayMetadataKey"`
+
+	// The amount of time between repeated deliveries of sent messages.
+	// Maps to the AMQ_SCHEDULED_PERIOD header.
+	ScheduledPeriod time.Duration `json:"scheduledPeriod" default:"0"`
+
+	// The metadata key that holds the scheduled period of a message, either as a
+	// duration (e.g. 10s) or in milliseconds.
+	ScheduledPeriodMetadataKey string `json:"scheduledPeriodMetadataKey"`
+
+	// The number of times the delivery of sent messages is repeated.
+	// Maps to the AMQ_SCHEDULED_REPEAT header.
+	ScheduledRepeat int `json:"scheduledRepeat" default:"0" validate:"gt=-1"`
+
+	// The metadata key that holds the scheduled repeat count of a message.
+	ScheduledRepeatMetadataKey string `json:"scheduledRepeatMetadataKey"`
+
+	// A cron expression that schedules the delivery of sent messages.
+	// Maps to the AMQ_SCHEDULED_CRON header.
+	ScheduledCron string `json:"scheduledCron"`
+
+	// The metadata key that holds the cron expression of a message.
+	ScheduledCronMetadataKey string `json:"scheduledCronMetadataKey"`
+}
+
+type ReceiptConfig struct {
+	// Flag to request a receipt from the broker for every sent message. A record
+	// is only reported as written once the broker confirmed that it received it.
+	Enabled bool `json:"enabled" default:"false"`
+
+	// The maximum amount of time to wait for the receipt of a sent message.
+	Timeout time.Duration `json:"timeout" default:"30s"`
+}
+
+type HeadersConfig struct {
+	// Flag to enable or disable sending record metadata with the activemq.header.
+	// prefix, and headers encoded as JSON in activemq.headers, as STOMP
+	// headers. Headers reserved by the broker, such as message-id and
+	// destination, are never sent. It is disabled by default, because records
+	// read from ActiveMQ carry headers that affect delivery, such as expires,
+	// priority, persistent and reply-to. Use headers.allow or headers.deny to
+	// control which of them are sent.
+	Enabled bool `json:"enabled" default:"false"`
+
+	// The names of the headers to send. If empty, all headers that are not
+	// denied are sent.
+	Allow []string `json:"allow"`
+
+	// The names of the headers that are not sent.
+	Deny []string `json:"deny"`
+}
+
+func (c *DestinationConfig) Validate(ctx context.Context) error {
+	errs := []error{
+		c.DefaultDestinationMiddleware.Validate(ctx),
+		c.Config.Validate(ctx),
+	}
+
+	if c.Queue == "" && c.QueueTemplate == "" && c.QueueMetadataKey == "" {
+		errs = append(errs, errors.New("one of queue, queueTemplate or queueMetadataKey is required"))
+	}
+	if c.QueueTemplate != "" && c.QueueMetadataKey != "" {
+		errs = append(errs, errors.New("queueTemplate and queueMetadataKey can't be used together"))
+	}
+	if c.QueueTemplate != "" {
+		if _, err := parseTemplate("queue", c.QueueTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Transactional && c.Connections > 1 {
+		errs = append(errs, errors.New("transactional can't be used with more than one connection"))
+	}
+	if c.PayloadMode == payloadModeTemplate {
+		if c.PayloadTemplate == "" {
+			errs = append(errs, errors.New("payload.template is required when payload.mode is template"))
+		} else if _, err := parseTemplate("payload", c.PayloadTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 type Destination struct {
 	sdk.UnimplementedDestination
 	config DestinationConfig
 
-	conn *stomp.Conn
+	// conns holds one connection manager per connection of the pool.
+	conns           []*connManager
+	queueTemplate   *template.Template
+	payloadTemplate *template.Template
 }
 
 func (d *Destination) Config() sdk.DestinationConfig {
@@ -44,10 +211,44 @@ func NewDestination() sdk.Destination {
 	return sdk.DestinationWithMiddleware(&Destination{})
 }
 
-func (d *Destination) Open(ctx context.Context) (err error) {
-	d.conn, err = connectDestination(ctx, d.config)
+func (d *Destination) Open(ctx context.Context) error {
+	if d.config.QueueTemplate != "" {
+		var err error
+		d.queueTemplate, err = parseTemplate("queue", d.config.QueueTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if d.config.PayloadMode == payloadModeTemplate {
+		var err error
+		d.payloadTemplate, err = parseTemplate("payload", d.config.PayloadTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	url, err := parseBrokerURL(d.config.URL, d.config.Reconnect)
 	if err != nil {
-		return fmt.Errorf("failed to dial to ActiveMQ: %w", err)
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+	url.warnIgnoredOptions(ctx)
+
+	d.conns = make([]*connManager, max(d.config.Connections, 1))
+	for i := range d.conns {
+		d.conns[i] = newConnManager(
+			url,
+			func(ctx context.Context, broker brokerAddr) (*stomp.Conn, error) {
+				return connectDestination(ctx, d.config, broker)
+			},
+			nil,
+		)
+		if err := d.conns[i].Open(ctx); err != nil {
+			d.conns = d.conns[:i]
+			return fmt.Errorf("failed to dial to ActiveMQ: %w", err)
+		}
+	}
+	if d.config.Transactional && d.config.BatchSize <= 1 {
+		sdk.Logger(ctx).Warn().Msg("transactional is enabled but sdk.batch.size is not greater than 1, every record is written in its own transaction")
 	}
 	sdk.Logger(ctx).Debug().Msg("opened destination")
 
@@ -55,17 +256,146 @@ func (d *Destination) Open(ctx context.Context) (err error) {
 }
 
 func (d *Destination) Write(ctx context.Context, records []opencdc.Record) (int, error) {
+	if d.config.Transactional {
+		return d.writeTransaction(ctx, records)
+	}
+
+	// Records up to the first one that can't be converted are still written.
+	msgs := make([]message, 0, len(records))
+	var msgErr error
+	for _, rec := range records {
+		msg, err := d.newMessage(rec)
+		if err != nil {
+			msgErr = err
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+
+	n, err := sendSharded(ctx, msgs, len(d.conns), d.send)
+	if err != nil {
+		return n, err
+	}
+
+	return n, msgErr
+}
+
+// writeTransaction writes all records in a single transaction, so that either
+// all or none of them are written.
+func (d *Destination) writeTransaction(ctx context.Context, records []opencdc.Record) (int, error) {
+	msgs := make([]message, len(records))
 	for i, rec := range records {
-		err := d.conn.Send(d.config.Queue, "application/json", rec.Bytes())
+		var err error
+		msgs[i], err = d.newMessage(rec)
 		if err != nil {
-			return i, fmt.Errorf("failed to send message: %w", err)
+			return 0, err
 		}
-		sdk.Logger(ctx).Trace().Str("queue", d.config.Queue).Msg("wrote record")
 	}
 
+	// Transactions are only allowed with a single connection.
+	connManager := d.conns[0]
+	err := d.sendTransaction(connManager.Conn(), msgs)
+	if err != nil && d.config.Reconnect.Enabled && isConnectionError(err) {
+		// The transaction died with the connection, send it again from scratch.
+		sdk.Logger(ctx).Warn().Err(err).Msg("lost connection to ActiveMQ")
+		conn, rerr := connManager.Reconnect(ctx)
+		if rerr != nil {
+			return 0, fmt.Errorf("%w: %w", err, rerr)
+		}
+		err = d.sendTransaction(conn, msgs)
+	}
+	if err != nil {
+		return 0, err
+	}
+	sdk.Logger(ctx).Trace().Int("records", len(records)).Msg("wrote records in transaction")
+
 	return len(records), nil
 }
 
+func (d *Destination) sendTransaction(conn *stomp.Conn, msgs []message) error {
+	tx, err := conn.BeginWithError()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, msg := range msgs {
+		if err := tx.Send(msg.queue, msg.contentType, msg.body, msg.opts...); err != nil {
+			err = fmt.Errorf("failed to send message in transaction: %w", err)
+			if abortErr := tx.Abort(); abortErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to abort transaction: %w", abortErr))
+			}
+			return err
+		}
+	}
+
+	commit := tx.Commit
+	if d.config.Receipt.Enabled {
+		commit = tx.CommitWithReceipt
+	}
+	if err := commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// queueFor returns the queue a record is written to. The queue template or
+// metadata key takes precedence, the configured queue is the fallback.
+func (d *Destination) queueFor(rec opencdc.Record) (string, error) {
+	var queue string
+	switch {
+	case d.queueTemplate != nil:
+		var sb strings.Builder
+		if err := d.queueTemplate.Execute(&sb, rec); err != nil {
+			return "", fmt.Errorf("failed to execute queue template: %w", err)
+		}
+		queue = strings.TrimSpace(sb.String())
+	case d.config.QueueMetadataKey != "":
+		queue = rec.Metadata[d.config.QueueMetadataKey]
+	}
+
+	if queue == "" {
+		queue = d.config.Queue
+	}
+	if queue == "" {
+		return "", fmt.Errorf("no queue found for record with position %q", rec.Position)
+	}
+
+	return queue, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v template: %w", name, err)
+	}
+
+	return t, nil
+}
+
+// send sends a message on the current connection with the given index. If the
+// connection turns out to be broken, it reconnects and sends the message again.
+func (d *Destination) send(ctx context.Context, conn int, msg message) error {
+	connManager := d.conns[conn]
+	err := connManager.Conn().Send(msg.queue, msg.contentType, msg.body, msg.opts...)
+	if err == nil || !d.config.Reconnect.Enabled || !isConnectionError(err) {
+		return err //nolint:wrapcheck // wrapped by the caller
+	}
+
+	sdk.Logger(ctx).Warn().Err(err).Int("connection", conn).Msg("lost connection to ActiveMQ")
+	stompConn, rerr := connManager.Reconnect(ctx)
+	if rerr != nil {
+		return fmt.Errorf("%w: %w", err, rerr)
+	}
+
+	return stompConn.Send(msg.queue, msg.contentType, msg.body, msg.opts...) //nolint:wrapcheck // wrapped by the caller
+}
+
 func (d *Destination) Teardown(ctx context.Context) error {
-	return teardown(ctx, nil, d.conn)
+	errs := make([]error, len(d.conns))
+	for i, conn := range d.conns {
+		errs[i] = teardown(ctx, nil, conn)
+	}
+
+	return errors.Join(errs...)
 }
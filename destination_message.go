@@ -0,0 +1,338 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/goccy/go-json"
+)
+
+const (
+	payloadModePayloadAfter = "payloadAfter"
+	payloadModeKey          = "key"
+	payloadModeTemplate     = "template"
+
+	contentTypeJSON   = "application/json"
+	contentTypeBinary = "application/octet-stream"
+	contentTypeText   = "text/plain"
+)
+
+// reservedHeaders are set by the client library or the broker and can't be
+// taken over from record metadata.
+var reservedHeaders = []string{
+	frame.Ack,
+	frame.ContentLength,
+	frame.ContentType,
+	frame.Destination,
+	frame.MessageId,
+	frame.Receipt,
+	frame.Subscription,
+	frame.Transaction,
+	"redelivered",
+	"timestamp",
+}
+
+// message is a STOMP message built from a record, ready to be sent.
+type message struct {
+	queue       string
+	contentType string
+	body        []byte
+	opts        []func(*frame.Frame) error
+	// shardKey decides which connection the message is sent on, messages
+	// with the same key are sent on the same connection. It is nil if the
+	// message can be sent on any connection.
+	shardKey []byte
+}
+
+// newMessage builds the message that is sent for a record.
+func (d *Destination) newMessage(rec opencdc.Record) (message, error) {
+	queue, err := d.queueFor(rec)
+	if err != nil {
+		return message{}, err
+	}
+
+	body, contentType, err := d.encode(rec)
+	if err != nil {
+		return message{}, err
+	}
+
+	headers, err := d.sendHeaders(rec)
+	if err != nil {
+		return message{}, err
+	}
+
+	var shardKey []byte
+	if group := headers.Get(groupIDHeader); group != "" {
+		shardKey = []byte(group)
+	} else if rec.Key != nil && len(rec.Key.Bytes()) > 0 {
+		shardKey = rec.Key.Bytes()
+	}
+
+	return message{
+		queue:       queue,
+		contentType: contentType,
+		body:        body,
+		opts:        d.sendOpts(headers),
+		shardKey:    shardKey,
+	}, nil
+}
+
+// encode builds the message body of a record according to the configured
+// payload mode, together with the matching content type.
+func (d *Destination) encode(rec opencdc.Record) (body []byte, contentType string, err error) {
+	switch d.config.PayloadMode {
+	case payloadModePayloadAfter:
+		body, contentType = dataBytes(rec.Payload.After)
+	case payloadModeKey:
+		body, contentType = dataBytes(rec.Key)
+	case payloadModeTemplate:
+		var buf bytes.Buffer
+		if err := d.payloadTemplate.Execute(&buf, rec); err != nil {
+			return nil, "", fmt.Errorf("failed to execute payload template: %w", err)
+		}
+		body, contentType = buf.Bytes(), contentTypeText
+	default: // record
+		body, contentType = rec.Bytes(), contentTypeJSON
+	}
+
+	if d.config.ContentType != "" {
+		contentType = d.config.ContentType
+	}
+
+	return body, contentType, nil
+}
+
+// dataBytes returns the bytes of the given data and the content type that
+// describes them. Structured data is encoded as JSON.
+func dataBytes(data opencdc.Data) ([]byte, string) {
+	switch data := data.(type) {
+	case nil:
+		return nil, contentTypeBinary
+	case opencdc.StructuredData:
+		return data.Bytes(), contentTypeJSON
+	default:
+		return data.Bytes(), contentTypeBinary
+	}
+}
+
+// sendHeaders returns the STOMP SEND frame headers for a record.
+func (d *Destination) sendHeaders(rec opencdc.Record) (*frame.Header, error) {
+	headers, err := deliveryHeaders(d.config.Delivery, rec.Metadata, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Delivery options take precedence over headers taken from metadata.
+	mapped, err := headersFromMetadata(d.config.Headers, rec.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	delivery := headers.Clone()
+	for i := range mapped.Len() {
+		k, v := mapped.GetAt(i)
+		if _, ok := delivery.Contains(k); !ok {
+			headers.Add(k, v)
+		}
+	}
+
+	return headers, nil
+}
+
+// sendOpts returns the STOMP SEND frame options for the given headers.
+func (d *Destination) sendOpts(headers *frame.Header) []func(*frame.Frame) error {
+	opts := make([]func(*frame.Frame) error, 0, headers.Len()+1)
+	for i := range headers.Len() {
+		k, v := headers.GetAt(i)
+		opts = append(opts, stomp.SendOpt.Header(k, v))
+	}
+
+	if d.config.Receipt.Enabled && !d.config.Transactional {
+		// Send blocks until the broker confirmed the message or the
+		// receipt timeout expired. Transactions wait for the receipt of the
+		// commit instead.
+		opts = append(opts, stomp.SendOpt.Receipt)
+	}
+
+	return opts
+}
+
+// deliveryHeaders builds the headers for the configured delivery options,
+// using the values from metadata where present.
+func deliveryHeaders(config DeliveryConfig, metadata opencdc.Metadata, now time.Time) (*frame.Header, error) {
+	headers := frame.NewHeader()
+
+	persistent, err := metadataOverride(metadata, config.PersistentMetadataKey, config.Persistent, strconv.ParseBool)
+	if err != nil {
+		return nil, err
+	}
+	if persistent {
+		headers.Add("persistent", "true")
+	}
+
+	priority, err := metadataOverride(metadata, config.PriorityMetadataKey, config.Priority, parsePriority)
+	if err != nil {
+		return nil, err
+	}
+	if priority > 0 {
+		headers.Add("priority", strconv.Itoa(priority))
+	}
+
+	ttl, err := metadataOverride(metadata, config.TimeToLiveMetadataKey, config.TimeToLive, parseMetadataDuration)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		headers.Add("expires", strconv.FormatInt(now.Add(ttl).UnixMilli(), 10))
+	}
+
+	delay, err := metadataOverride(metadata, config.ScheduledDelayMetadataKey, config.ScheduledDelay, parseMetadataDuration)
+	if err != nil {
+		return nil, err
+	}
+	if delay > 0 {
+		headers.Add("AMQ_SCHEDULED_DELAY", strconv.FormatInt(delay.Milliseconds(), 10))
+	}
+
+	period, err := metadataOverride(metadata, config.ScheduledPeriodMetadataKey, config.ScheduledPeriod, parseMetadataDuration)
+	if err != nil {
+		return nil, err
+	}
+	if period > 0 {
+		headers.Add("AMQ_SCHEDULED_PERIOD", strconv.FormatInt(period.Milliseconds(), 10))
+	}
+
+	repeat, err := metadataOverride(metadata, config.ScheduledRepeatMetadataKey, config.ScheduledRepeat, strconv.Atoi)
+	if err != nil {
+		return nil, err
+	}
+	if repeat > 0 {
+		headers.Add("AMQ_SCHEDULED_REPEAT", strconv.Itoa(repeat))
+	}
+
+	cron, _ := metadataOverride(metadata, config.ScheduledCronMetadataKey, config.ScheduledCron, parseString)
+	if cron != "" {
+		headers.Add("AMQ_SCHEDULED_CRON", cron)
+	}
+
+	return headers, nil
+}
+
+// metadataOverride returns the parsed value of the given metadata key, or the
+// static value if the key isn't configured or not present in the metadata.
+func metadataOverride[T any](
+	metadata opencdc.Metadata,
+	key string,
+	static T,
+	parse func(string) (T, error),
+) (T, error) {
+	v, ok := metadata[key]
+	if key == "" || !ok {
+		return static, nil
+	}
+
+	parsed, err := parse(v)
+	if err != nil {
+		return static, fmt.Errorf("invalid value %q in metadata key %q: %w", v, key, err)
+	}
+
+	return parsed, nil
+}
+
+func parsePriority(v string) (int, error) {
+	priority, err := strconv.Atoi(v)
+	if err != nil || priority < 0 || priority > 9 {
+		return 0, errors.New("expected a number from 0 to 9")
+	}
+
+	return priority, nil
+}
+
+func parseString(v string) (string, error) {
+	return v, nil
+}
+
+// parseMetadataDuration parses a duration such as 10s, or a plain number of
+// milliseconds as used by ActiveMQ.
+func parseMetadataDuration(v string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("expected a duration or a number of milliseconds: %w", err)
+	}
+
+	return d, nil
+}
+
+// headersFromMetadata is the reverse of metadataFromMsg, it turns metadata with
+// the activemq.header. prefix into STOMP headers, sorted by name. Headers
+// encoded as JSON in activemq.headers are added first, with all their values,
+// and take precedence over headers with the same name under the prefix.
+func headersFromMetadata(config HeadersConfig, metadata opencdc.Metadata) (*frame.Header, error) {
+	headers := frame.NewHeader()
+	if !config.Enabled {
+		return headers, nil
+	}
+
+	allowed := func(name string) bool {
+		return name != "" &&
+			!slices.Contains(reservedHeaders, name) &&
+			!slices.Contains(config.Deny, name) &&
+			(len(config.Allow) == 0 || slices.Contains(config.Allow, name))
+	}
+
+	if encoded, ok := metadata[metadataHeaders]; ok {
+		var values map[string][]string
+		if err := json.Unmarshal([]byte(encoded), &values); err != nil {
+			return nil, fmt.Errorf("invalid JSON in metadata key %q: %w", metadataHeaders, err)
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			if !allowed(name) {
+				continue
+			}
+			for _, v := range values[name] {
+				headers.Add(name, v)
+			}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		name, ok := strings.CutPrefix(key, metadataHeaderPrefix)
+		if !ok || !allowed(name) {
+			continue
+		}
+		if _, ok := headers.Contains(name); ok {
+			continue
+		}
+
+		headers.Add(name, metadata[key])
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,104 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+)
+
+// groupIDHeader is the header of the message group a message belongs to.
+// ActiveMQ delivers the messages of a group in order to a single consumer.
+const groupIDHeader = "JMSXGroupID"
+
+// shardFor returns the index of the connection the i-th message of a batch is
+// sent on. Messages without a shard key are spread evenly.
+func shardFor(msg message, i, shards int) int {
+	if msg.shardKey == nil {
+		return i % shards
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(msg.shardKey)
+	return int(h.Sum32() % uint32(shards)) //nolint:gosec // shards is positive
+}
+
+// sendSharded sends the messages of a batch on the given number of
+// connections concurrently, the messages of a connection are sent in order. It
+// returns the number of messages at the start of the batch that were sent.
+// When a message fails, connections stop sending messages that come after it
+// in the batch. Messages that were sent anyway are sent again when the batch
+// is retried.
+func sendSharded(
+	ctx context.Context,
+	msgs []message,
+	shards int,
+	send func(ctx context.Context, shard int, msg message) error,
+) (int, error) {
+	indexes := make([][]int, shards)
+	for i, msg := range msgs {
+		shard := shardFor(msg, i, shards)
+		indexes[shard] = append(indexes[shard], i)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failedAt = len(msgs)
+		firstErr error
+	)
+	failed := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return i > failedAt
+	}
+
+	for shard, shardIndexes := range indexes {
+		if len(shardIndexes) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range shardIndexes {
+				if failed(i) {
+					return
+				}
+
+				if err := send(ctx, shard, msgs[i]); err != nil {
+					mu.Lock()
+					if i < failedAt {
+						failedAt, firstErr = i, err
+					}
+					mu.Unlock()
+					return
+				}
+				sdk.Logger(ctx).Trace().Str("queue", msgs[i].queue).Int("connection", shard).Msg("wrote record")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return failedAt, fmt.Errorf("failed to send message: %w", firstErr)
+	}
+
+	return len(msgs), nil
+}
@@ -0,0 +1,234 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+	"github.com/matryer/is"
+)
+
+func TestShardFor(t *testing.T) {
+	is := is.New(t)
+
+	keyed := message{shardKey: []byte("group-1")}
+	shard := shardFor(keyed, 0, 4)
+	for i := range 10 {
+		is.Equal(shardFor(keyed, i, 4), shard)
+	}
+
+	// Messages without a shard key are spread across the connections.
+	for i := range 8 {
+		is.Equal(shardFor(message{}, i, 4), i%4)
+	}
+}
+
+func TestSendShardedKeepsOrderPerKey(t *testing.T) {
+	is := is.New(t)
+
+	var msgs []message
+	for i := range 20 {
+		msgs = append(msgs, message{
+			queue:    string(rune('a' + i)),
+			shardKey: []byte{byte(i % 3)},
+		})
+	}
+
+	var mu sync.Mutex
+	sent := make(map[string][]string)
+	n, err := sendSharded(context.Background(), msgs, 4, func(_ context.Context, _ int, msg message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[string(msg.shardKey)] = append(sent[string(msg.shardKey)], msg.queue)
+		return nil
+	})
+	is.NoErr(err)
+	is.Equal(n, len(msgs))
+
+	for key, queues := range sent {
+		var want []string
+		for _, msg := range msgs {
+			if string(msg.shardKey) == key {
+				want = append(want, msg.queue)
+			}
+		}
+		is.Equal(queues, want)
+	}
+}
+
+func TestSendShardedFailure(t *testing.T) {
+	is := is.New(t)
+
+	msgs := make([]message, 10)
+	for i := range msgs {
+		msgs[i] = message{queue: string(rune('a' + i))}
+	}
+
+	// The message at index 5 fails, messages before it are written on the
+	// other connections.
+	wantErr := errors.New("boom")
+	var mu sync.Mutex
+	var sent []string
+	n, err := sendSharded(context.Background(), msgs, 3, func(_ context.Context, _ int, msg message) error {
+		if msg.queue == "f" {
+			return wantErr
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, msg.queue)
+		return nil
+	})
+	is.True(errors.Is(err, wantErr))
+	is.Equal(n, 5)
+	for _, queue := range []string{"a", "b", "c", "d", "e"} {
+		is.True(slices.Contains(sent, queue))
+	}
+}
+
+// maxLogins is a STOMP server authenticator that rejects logins once the limit
+// is reached.
+type maxLogins struct {
+	limit  int32
+	logins atomic.Int32
+}
+
+func (a *maxLogins) Authenticate(_, _ string) bool {
+	return a.logins.Add(1) <= a.limit
+}
+
+// newTestBroker starts an in-memory STOMP server and returns its address.
+func newTestBroker(t *testing.T, auth server.Authenticator) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		_ = (&server.Server{Authenticator: auth}).Serve(l)
+	}()
+
+	return l.Addr().String()
+}
+
+func TestDestinationWriteShardedConversionError(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	addr := newTestBroker(t, nil)
+
+	consumer, err := stomp.Dial("tcp", addr)
+	is.NoErr(err)
+	defer consumer.MustDisconnect() //nolint:errcheck // closing the test connection
+	sub, err := consumer.Subscribe("/queue/orders", stomp.AckAuto)
+	is.NoErr(err)
+
+	d := &Destination{config: DestinationConfig{
+		Config:           Config{URL: addr},
+		QueueMetadataKey: "queue",
+		PayloadMode:      payloadModeKey,
+		Connections:      3,
+	}}
+	is.NoErr(d.Open(ctx))
+	defer func() { is.NoErr(d.Teardown(ctx)) }()
+
+	// The record at index 5 has no queue, so it can't be converted.
+	records := make([]opencdc.Record, 10)
+	for i := range records {
+		records[i] = opencdc.Record{
+			Key:      opencdc.RawData(strconv.Itoa(i)),
+			Metadata: opencdc.Metadata{"queue": "/queue/orders"},
+		}
+	}
+	delete(records[5].Metadata, "queue")
+
+	n, err := d.Write(ctx, records)
+	is.True(err != nil)
+	is.Equal(n, 5)
+
+	// Only the records before the failed one were sent.
+	var keys []string
+	for range 5 {
+		select {
+		case msg := <-sub.C:
+			is.NoErr(msg.Err)
+			keys = append(keys, string(msg.Body))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+	slices.Sort(keys)
+	is.Equal(keys, []string{"0", "1", "2", "3", "4"})
+
+	select {
+	case msg := <-sub.C:
+		t.Fatalf("unexpected message %q", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDestinationOpenKeepsOpenedConnections(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	addr := newTestBroker(t, &maxLogins{limit: 2})
+
+	d := &Destination{config: DestinationConfig{
+		Config:      Config{URL: addr},
+		Queue:       "orders",
+		Connections: 3,
+	}}
+
+	// The third connection is rejected, the first two are kept so that
+	// Teardown closes them.
+	is.True(d.Open(ctx) != nil)
+	is.Equal(len(d.conns), 2)
+	for _, conn := range d.conns {
+		is.True(conn.Conn() != nil)
+	}
+
+	is.NoErr(d.Teardown(ctx))
+	for _, conn := range d.conns {
+		is.Equal(conn.Conn(), nil)
+	}
+}
+
+func TestDestinationConfigValidateConnections(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := DestinationConfig{
+		Config:        Config{URL: "localhost:61613"},
+		Queue:         "orders",
+		Transactional: true,
+		Connections:   1,
+	}
+	is.NoErr(config.Validate(ctx))
+
+	config.Connections = 2
+	is.True(config.Validate(ctx) != nil)
+
+	config.Transactional = false
+	is.NoErr(config.Validate(ctx))
+}
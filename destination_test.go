@@ -0,0 +1,448 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/matryer/is"
+)
+
+func TestDestinationQueueFor(t *testing.T) {
+	rec := opencdc.Record{
+		Metadata: opencdc.Metadata{
+			opencdc.MetadataCollection: "users",
+			"target":                   "audit",
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		config DestinationConfig
+		want   string
+	}{
+		{
+			name:   "static queue",
+			config: DestinationConfig{Queue: "fallback"},
+			want:   "fallback",
+		},
+		{
+			name: "template",
+			config: DestinationConfig{
+				Queue:         "fallback",
+				QueueTemplate: `cdc.{{ index .Metadata "opencdc.collection" }}`,
+			},
+			want: "cdc.users",
+		},
+		{
+			name: "template without result",
+			config: DestinationConfig{
+				Queue:         "fallback",
+				QueueTemplate: `{{ index .Metadata "missing" }}`,
+			},
+			want: "fallback",
+		},
+		{
+			name:   "metadata key",
+			config: DestinationConfig{Queue: "fallback", QueueMetadataKey: "target"},
+			want:   "audit",
+		},
+		{
+			name:   "missing metadata key",
+			config: DestinationConfig{Queue: "fallback", QueueMetadataKey: "missing"},
+			want:   "fallback",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			d := &Destination{config: tc.config}
+			if tc.config.QueueTemplate != "" {
+				var err error
+				d.queueTemplate, err = parseTemplate("queue", tc.config.QueueTemplate)
+				is.NoErr(err)
+			}
+
+			queue, err := d.queueFor(rec)
+			is.NoErr(err)
+			is.Equal(queue, tc.want)
+		})
+	}
+}
+
+func TestDestinationQueueForNoQueue(t *testing.T) {
+	is := is.New(t)
+
+	d := &Destination{config: DestinationConfig{QueueMetadataKey: "target"}}
+	_, err := d.queueFor(opencdc.Record{Metadata: opencdc.Metadata{}})
+	is.True(err != nil)
+}
+
+func TestDestinationEncode(t *testing.T) {
+	rec := opencdc.Record{
+		Operation: opencdc.OperationCreate,
+		Key:       opencdc.RawData("id-1"),
+		Payload: opencdc.Change{
+			After: opencdc.StructuredData{"name": "conduit"},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		config          DestinationConfig
+		wantBody        string
+		wantContentType string
+	}{
+		{
+			name:            "payload after",
+			config:          DestinationConfig{PayloadMode: "payloadAfter"},
+			wantBody:        `{"name":"conduit"}`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "key",
+			config:          DestinationConfig{PayloadMode: "key"},
+			wantBody:        "id-1",
+			wantContentType: "application/octet-stream",
+		},
+		{
+			name:            "template",
+			config:          DestinationConfig{PayloadMode: "template", PayloadTemplate: `{{ .Operation }}:{{ printf "%s" .Key }}`},
+			wantBody:        "create:id-1",
+			wantContentType: "text/plain",
+		},
+		{
+			name:            "content type override",
+			config:          DestinationConfig{PayloadMode: "key", ContentType: "text/plain"},
+			wantBody:        "id-1",
+			wantContentType: "text/plain",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			d := &Destination{config: tc.config}
+			if tc.config.PayloadTemplate != "" {
+				var err error
+				d.payloadTemplate, err = parseTemplate("payload", tc.config.PayloadTemplate)
+				is.NoErr(err)
+			}
+
+			body, contentType, err := d.encode(rec)
+			is.NoErr(err)
+			is.Equal(string(body), tc.wantBody)
+			is.Equal(contentType, tc.wantContentType)
+		})
+	}
+}
+
+func TestHeadersFromMetadata(t *testing.T) {
+	metadata := opencdc.Metadata{
+		"activemq.header.message-id":       "ID:broker-1",
+		"activemq.header.destination":      "/queue/orders",
+		"activemq.header.JMSCorrelationID": "corr-1",
+		"activemq.header.JMSType":          "order",
+		"activemq.header.custom":           "value",
+		opencdc.MetadataCollection:         "orders",
+	}
+
+	testCases := []struct {
+		name   string
+		config HeadersConfig
+		want   map[string]string
+	}{
+		{
+			name:   "disabled",
+			config: HeadersConfig{Enabled: false},
+			want:   map[string]string{},
+		},
+		{
+			name:   "all",
+			config: HeadersConfig{Enabled: true},
+			want: map[string]string{
+				"JMSCorrelationID": "corr-1",
+				"JMSType":          "order",
+				"custom":           "value",
+			},
+		},
+		{
+			name:   "allow",
+			config: HeadersConfig{Enabled: true, Allow: []string{"JMSType", "message-id"}},
+			want:   map[string]string{"JMSType": "order"},
+		},
+		{
+			name:   "deny",
+			config: HeadersConfig{Enabled: true, Deny: []string{"custom"}},
+			want: map[string]string{
+				"JMSCorrelationID": "corr-1",
+				"JMSType":          "order",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			headers, err := headersFromMetadata(tc.config, metadata)
+			is.NoErr(err)
+
+			got := make(map[string]string)
+			for i := range headers.Len() {
+				k, v := headers.GetAt(i)
+				got[k] = v
+			}
+			is.Equal(got, tc.want)
+		})
+	}
+}
+
+func TestDestinationSendOptsReceipt(t *testing.T) {
+	is := is.New(t)
+
+	d := &Destination{config: DestinationConfig{Receipt: ReceiptConfig{Enabled: true}}}
+
+	f := frame.New(frame.SEND)
+	headers, err := d.sendHeaders(opencdc.Record{})
+	is.NoErr(err)
+	for _, opt := range d.sendOpts(headers) {
+		is.NoErr(opt(f))
+	}
+
+	_, ok := f.Header.Contains(frame.Receipt)
+	is.True(ok)
+}
+
+func TestDeliveryHeaders(t *testing.T) {
+	is := is.New(t)
+	now := time.UnixMilli(1700000000000)
+
+	config := DeliveryConfig{
+		Persistent:                true,
+		Priority:                  4,
+		PriorityMetadataKey:       "priority",
+		TimeToLive:                time.Minute,
+		ScheduledDelayMetadataKey: "delay",
+		ScheduledRepeat:           3,
+		ScheduledCron:             "0 * * * *",
+	}
+	metadata := opencdc.Metadata{
+		"priority": "9",
+		"delay":    "1500",
+	}
+
+	headers, err := deliveryHeaders(config, metadata, now)
+	is.NoErr(err)
+	is.Equal(headers.Get("persistent"), "true")
+	is.Equal(headers.Get("priority"), "9")
+	is.Equal(headers.Get("expires"), "1700000060000")
+	is.Equal(headers.Get("AMQ_SCHEDULED_DELAY"), "1500")
+	is.Equal(headers.Get("AMQ_SCHEDULED_REPEAT"), "3")
+	is.Equal(headers.Get("AMQ_SCHEDULED_CRON"), "0 * * * *")
+	_, ok := headers.Contains("AMQ_SCHEDULED_PERIOD")
+	is.True(!ok)
+
+	metadata["delay"] = "10s"
+	headers, err = deliveryHeaders(config, metadata, now)
+	is.NoErr(err)
+	is.Equal(headers.Get("AMQ_SCHEDULED_DELAY"), "10000")
+
+	metadata["priority"] = "high"
+	_, err = deliveryHeaders(config, metadata, now)
+	is.True(err != nil)
+}
+
+func TestHeadersFromMetadataJSONRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	header := &frame.Header{}
+	header.Add("message-id", "ID:1")
+	header.Add("tags", "a, b")
+	header.Add("tags", "c")
+	header.Add("JMSType", "order")
+
+	metadata := opencdc.Metadata{
+		metadataHeaders: metadataHeadersJSON(&stomp.Message{Header: header}),
+		// Headers in the JSON encoding take precedence.
+		"activemq.header.JMSType": "other",
+	}
+
+	headers, err := headersFromMetadata(HeadersConfig{Enabled: true}, metadata)
+	is.NoErr(err)
+
+	want := frame.NewHeader("JMSType", "order", "tags", "a, b", "tags", "c")
+	is.Equal(headers, want)
+
+	_, err = headersFromMetadata(HeadersConfig{Enabled: true}, opencdc.Metadata{metadataHeaders: "not json"})
+	is.True(err != nil)
+}
+
+func TestDestinationParameters(t *testing.T) {
+	is := is.New(t)
+
+	// Same checks as the SDK acceptance test, except that the map of CONNECT
+	// headers shared with the source is allowed.
+	paramNameRegex := regexp.MustCompile(`^[a-zA-Z0-9.]+$`)
+	params := Connector.NewSpecification().DestinationParams
+	is.True(len(params) > 0)
+	for name, p := range params {
+		is.True(name == "connectHeaders.*" || paramNameRegex.MatchString(name)) // parameter contains invalid characters
+		is.True(p.Description != "")                                            // parameter description is empty
+	}
+}
+
+// newTestTransactionalDestination opens a transactional destination writing
+// to the queue orders on the given broker.
+func newTestTransactionalDestination(t *testing.T, addr string, reconnect bool) *Destination {
+	is := is.New(t)
+	ctx := context.Background()
+
+	d := &Destination{config: DestinationConfig{
+		Config: Config{
+			URL: addr,
+			Reconnect: ReconnectConfig{
+				Enabled:        reconnect,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				BackoffFactor:  2,
+			},
+		},
+		Queue:         "/queue/orders",
+		PayloadMode:   payloadModeKey,
+		Transactional: true,
+		Connections:   1,
+	}}
+	is.NoErr(d.Open(ctx))
+	t.Cleanup(func() { is.NoErr(d.Teardown(ctx)) })
+
+	return d
+}
+
+// receiveKeys subscribes to the queue orders and returns a function that
+// returns the bodies of the messages received until no message arrived for a
+// short while.
+func receiveKeys(t *testing.T, addr string) func() []string {
+	is := is.New(t)
+
+	consumer, err := stomp.Dial("tcp", addr)
+	is.NoErr(err)
+	t.Cleanup(func() { _ = consumer.Disconnect() })
+	sub, err := consumer.Subscribe("/queue/orders", stomp.AckAuto)
+	is.NoErr(err)
+
+	return func() []string {
+		var keys []string
+		for {
+			select {
+			case msg := <-sub.C:
+				is.NoErr(msg.Err)
+				keys = append(keys, string(msg.Body))
+			case <-time.After(200 * time.Millisecond):
+				return keys
+			}
+		}
+	}
+}
+
+func testRecords(keys ...string) []opencdc.Record {
+	records := make([]opencdc.Record, len(keys))
+	for i, key := range keys {
+		records[i] = opencdc.Record{Key: opencdc.RawData(key)}
+	}
+	return records
+}
+
+func TestDestinationWriteTransactionCommit(t *testing.T) {
+	is := is.New(t)
+	addr := newTestBroker(t, nil)
+	received := receiveKeys(t, addr)
+	d := newTestTransactionalDestination(t, addr, false)
+
+	n, err := d.Write(context.Background(), testRecords("1", "2", "3"))
+	is.NoErr(err)
+	is.Equal(n, 3)
+	is.Equal(received(), []string{"1", "2", "3"})
+}
+
+func TestDestinationWriteTransactionConversionError(t *testing.T) {
+	is := is.New(t)
+	addr := newTestBroker(t, nil)
+	received := receiveKeys(t, addr)
+	d := newTestTransactionalDestination(t, addr, false)
+	d.config.Queue = ""
+	d.config.QueueMetadataKey = "queue"
+
+	// The second record has no queue, so none of the records are sent.
+	records := testRecords("1", "2", "3")
+	records[0].Metadata = opencdc.Metadata{"queue": "/queue/orders"}
+	records[2].Metadata = opencdc.Metadata{"queue": "/queue/orders"}
+
+	n, err := d.Write(context.Background(), records)
+	is.True(err != nil)
+	is.Equal(n, 0)
+	is.Equal(received(), nil)
+}
+
+func TestDestinationWriteTransactionAbort(t *testing.T) {
+	is := is.New(t)
+	addr := newTestBroker(t, nil)
+	received := receiveKeys(t, addr)
+	d := newTestTransactionalDestination(t, addr, false)
+
+	// The second message can't be sent, so the transaction is aborted and
+	// the first message is discarded by the broker.
+	sendErr := errors.New("boom")
+	msgs := []message{
+		{queue: "/queue/orders", body: []byte("1")},
+		{queue: "/queue/orders", body: []byte("2"), opts: []func(*frame.Frame) error{
+			func(*frame.Frame) error { return sendErr },
+		}},
+	}
+	err := d.sendTransaction(d.conns[0].Conn(), msgs)
+	is.True(errors.Is(err, sendErr))
+	is.Equal(received(), nil)
+
+	// A connection that is gone fails the whole batch.
+	is.NoErr(d.conns[0].Conn().Disconnect())
+	n, err := d.Write(context.Background(), testRecords("1", "2"))
+	is.True(err != nil)
+	is.Equal(n, 0)
+	is.Equal(received(), nil)
+}
+
+func TestDestinationWriteTransactionReconnect(t *testing.T) {
+	is := is.New(t)
+	addr := newTestBroker(t, nil)
+	received := receiveKeys(t, addr)
+	d := newTestTransactionalDestination(t, addr, true)
+
+	// The transaction is sent again from scratch on a new connection.
+	is.NoErr(d.conns[0].Conn().Disconnect())
+	n, err := d.Write(context.Background(), testRecords("1", "2"))
+	is.NoErr(err)
+	is.Equal(n, 2)
+	is.Equal(received(), []string{"1", "2"})
+}
@@ -0,0 +1,216 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+)
+
+const failoverPrefix = "failover:"
+
+// brokerAddr is a single broker taken from the configured URL.
+type brokerAddr struct {
+	// scheme is the transport scheme of the broker URI, empty if the broker
+	// was given as a plain host:port.
+	scheme string
+	// host is the host:port to dial.
+	host string
+	// path is the HTTP path of WebSocket brokers.
+	path string
+}
+
+// useTLS reports whether the scheme of the broker requires TLS.
+func (b brokerAddr) useTLS() bool {
+	return b.scheme == "ssl" || b.scheme == "wss" || strings.HasSuffix(b.scheme, "+ssl")
+}
+
+// useWebSocket reports whether the broker is reached over STOMP over WebSocket.
+func (b brokerAddr) useWebSocket() bool {
+	return b.scheme == "ws" || b.scheme == "wss"
+}
+
+func (b brokerAddr) String() string {
+	if b.scheme == "" {
+		return b.host
+	}
+	return b.scheme + "://" + b.host + b.path
+}
+
+// brokerURL is the parsed Config.URL. It either contains a single broker or,
+// when using the ActiveMQ failover syntax, a list of brokers together with the
+// reconnect settings taken from the failover options.
+type brokerURL struct {
+	brokers   []brokerAddr
+	reconnect ReconnectConfig
+	// ignoredOptions are the failover options that are not supported by the
+	// connector, e.g. timeout or priorityBackup.
+	ignoredOptions []string
+}
+
+// warnIgnoredOptions logs the failover options that have no effect.
+func (u brokerURL) warnIgnoredOptions(ctx context.Context) {
+	if len(u.ignoredOptions) > 0 {
+		sdk.Logger(ctx).Warn().
+			Strs("options", u.ignoredOptions).
+			Msg("ignoring unsupported failover options")
+	}
+}
+
+// parseBrokerURL parses a URL in the form of host:port, scheme://host:port or
+// failover:(uri1,uri2)?option=value. Failover options override the given
+// reconnect configuration, unsupported options are ignored, so that URIs
+// taken from existing ActiveMQ clients can be used as they are. See
+// https://activemq.apache.org/components/classic/documentation/failover-transport-reference
+func parseBrokerURL(rawURL string, reconnect ReconnectConfig) (brokerURL, error) {
+	parsed := brokerURL{reconnect: reconnect}
+
+	if !strings.HasPrefix(rawURL, failoverPrefix) {
+		broker, err := parseBrokerAddr(rawURL)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.brokers = []brokerAddr{broker}
+
+		return parsed, nil
+	}
+
+	var uris, rawQuery string
+	rest := strings.TrimPrefix(rawURL, failoverPrefix)
+	if strings.HasPrefix(rest, "(") {
+		// The nested URIs can have options of their own, so the failover
+		// options only start after the closing parenthesis.
+		end := strings.LastIndex(rest, ")")
+		if end < 0 {
+			return parsed, fmt.Errorf("invalid failover URL %q: missing closing parenthesis", rawURL)
+		}
+		uris = rest[1:end]
+		rawQuery = strings.TrimPrefix(rest[end+1:], "?")
+	} else {
+		uris, rawQuery, _ = strings.Cut(rest, "?")
+	}
+
+	for _, uri := range strings.Split(uris, ",") {
+		broker, err := parseBrokerAddr(strings.TrimSpace(uri))
+		if err != nil {
+			return parsed, err
+		}
+		parsed.brokers = append(parsed.brokers, broker)
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid failover options %q: %w", rawQuery, err)
+	}
+
+	// Same defaults as the ActiveMQ failover transport.
+	randomize, exponential := true, true
+	for key, values := range query {
+		value := values[len(values)-1]
+
+		switch key {
+		case "randomize":
+			randomize, err = strconv.ParseBool(value)
+		case "maxReconnectAttempts":
+			var attempts int
+			attempts, err = strconv.Atoi(value)
+			switch {
+			case attempts < 0:
+				parsed.reconnect.MaxAttempts = 0
+			case attempts == 0:
+				parsed.reconnect.Enabled = false
+			default:
+				parsed.reconnect.MaxAttempts = attempts
+			}
+		case "initialReconnectDelay":
+			parsed.reconnect.InitialBackoff, err = parseMillis(value)
+		case "maxReconnectDelay":
+			parsed.reconnect.MaxBackoff, err = parseMillis(value)
+		case "backOffMultiplier":
+			parsed.reconnect.BackoffFactor, err = strconv.ParseFloat(value, 64)
+		case "useExponentialBackOff":
+			exponential, err = strconv.ParseBool(value)
+		default:
+			parsed.ignoredOptions = append(parsed.ignoredOptions, key)
+		}
+		if err != nil {
+			return parsed, fmt.Errorf("invalid value %q for failover option %q: %w", value, key, err)
+		}
+	}
+
+	slices.Sort(parsed.ignoredOptions)
+
+	if !exponential {
+		parsed.reconnect.BackoffFactor = 1
+	}
+
+	if randomize {
+		//nolint:gosec // no need for a cryptographically secure shuffle
+		rand.Shuffle(len(parsed.brokers), func(i, j int) {
+			parsed.brokers[i], parsed.brokers[j] = parsed.brokers[j], parsed.brokers[i]
+		})
+	}
+
+	return parsed, nil
+}
+
+func parseBrokerAddr(uri string) (brokerAddr, error) {
+	if uri == "" {
+		return brokerAddr{}, errors.New("empty broker URI")
+	}
+
+	scheme, host, ok := strings.Cut(uri, "://")
+	if !ok {
+		return brokerAddr{host: uri}, nil
+	}
+
+	switch scheme {
+	case "tcp", "stomp", "stomp+nio", "ssl", "stomp+ssl", "stomp+nio+ssl", "ws", "wss":
+	default:
+		return brokerAddr{}, fmt.Errorf("unsupported scheme %q in broker URI %q", scheme, uri)
+	}
+
+	// Transport options such as tcp://host:port?wireFormat.maxInactivityDuration=0
+	// only apply to the broker side, we only need the address.
+	host, _, _ = strings.Cut(host, "?")
+	host, path, _ := strings.Cut(host, "/")
+	if host == "" {
+		return brokerAddr{}, fmt.Errorf("missing host in broker URI %q", uri)
+	}
+
+	broker := brokerAddr{scheme: scheme, host: host}
+	if broker.useWebSocket() && path != "" {
+		broker.path = "/" + path
+	}
+
+	return broker, nil
+}
+
+func parseMillis(value string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err //nolint:wrapcheck // wrapped by the caller
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
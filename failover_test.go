@@ -0,0 +1,142 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParseBrokerURL(t *testing.T) {
+	defaults := ReconnectConfig{
+		Enabled:        true,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		BackoffFactor:  2,
+	}
+
+	testCases := []struct {
+		name      string
+		url       string
+		brokers   []brokerAddr
+		reconnect ReconnectConfig
+	}{
+		{
+			name:      "plain host and port",
+			url:       "localhost:61613",
+			brokers:   []brokerAddr{{host: "localhost:61613"}},
+			reconnect: defaults,
+		},
+		{
+			name:      "broker URI",
+			url:       "ssl://localhost:61614",
+			brokers:   []brokerAddr{{scheme: "ssl", host: "localhost:61614"}},
+			reconnect: defaults,
+		},
+		{
+			name:      "websocket URI with path",
+			url:       "wss://broker.example.com:443/stomp?transport.maxIdleTime=0",
+			brokers:   []brokerAddr{{scheme: "wss", host: "broker.example.com:443", path: "/stomp"}},
+			reconnect: defaults,
+		},
+		{
+			name:      "websocket URI without path",
+			url:       "ws://localhost:61614/",
+			brokers:   []brokerAddr{{scheme: "ws", host: "localhost:61614"}},
+			reconnect: defaults,
+		},
+		{
+			name: "failover without options",
+			url:  "failover:(tcp://a:61613,tcp://b:61613)?randomize=false",
+			brokers: []brokerAddr{
+				{scheme: "tcp", host: "a:61613"},
+				{scheme: "tcp", host: "b:61613"},
+			},
+			reconnect: defaults,
+		},
+		{
+			name: "failover with nested options",
+			url:  "failover:(tcp://a:61613?wireFormat.maxInactivityDuration=0,stomp+ssl://b:61614)?randomize=false&maxReconnectAttempts=5&initialReconnectDelay=10&maxReconnectDelay=1000&useExponentialBackOff=false",
+			brokers: []brokerAddr{
+				{scheme: "tcp", host: "a:61613"},
+				{scheme: "stomp+ssl", host: "b:61614"},
+			},
+			reconnect: ReconnectConfig{
+				Enabled:        true,
+				MaxAttempts:    5,
+				InitialBackoff: 10 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				BackoffFactor:  1,
+			},
+		},
+		{
+			name:    "failover with reconnect disabled",
+			url:     "failover:tcp://a:61613?maxReconnectAttempts=0",
+			brokers: []brokerAddr{{scheme: "tcp", host: "a:61613"}},
+			reconnect: ReconnectConfig{
+				Enabled:        false,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     30 * time.Second,
+				BackoffFactor:  2,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			parsed, err := parseBrokerURL(tc.url, defaults)
+			is.NoErr(err)
+			is.Equal(parsed.brokers, tc.brokers)
+			is.Equal(parsed.reconnect, tc.reconnect)
+		})
+	}
+}
+
+func TestParseBrokerURLIgnoredOptions(t *testing.T) {
+	is := is.New(t)
+
+	url := "failover:(tcp://a:61613,tcp://b:61613)?timeout=3000&randomize=false" +
+		"&priorityBackup=true&startupMaxReconnectAttempts=5&maxReconnectAttempts=3"
+	parsed, err := parseBrokerURL(url, ReconnectConfig{Enabled: true})
+	is.NoErr(err)
+	is.Equal(parsed.brokers, []brokerAddr{{scheme: "tcp", host: "a:61613"}, {scheme: "tcp", host: "b:61613"}})
+	is.Equal(parsed.reconnect.MaxAttempts, 3)
+	is.Equal(parsed.ignoredOptions, []string{"priorityBackup", "startupMaxReconnectAttempts", "timeout"})
+
+	// The options don't make the config invalid.
+	is.NoErr(Config{URL: url}.Validate(context.Background()))
+}
+
+func TestParseBrokerURLErrors(t *testing.T) {
+	for _, url := range []string{
+		"",
+		"failover:(tcp://a:61613",
+		"failover:(tcp://a:61613)?randomize=maybe",
+		"http://a:61613",
+		"tcp://",
+	} {
+		t.Run(url, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := parseBrokerURL(url, ReconnectConfig{})
+			is.True(err != nil)
+		})
+	}
+}
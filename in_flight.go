@@ -0,0 +1,206 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// delivery is a message that was read by the source and is waiting to be acked.
+type delivery struct {
+	msg    *stomp.Message
+	readAt time.Time
+	// nacked is set once the message was negatively acknowledged because it
+	// wasn't acked in time.
+	nacked bool
+	// acked is set once Conduit acked the message, in ordered mode it can
+	// still wait for earlier messages to be acked.
+	acked bool
+}
+
+// inFlight tracks the deliveries that are waiting to be acked, by message ID.
+// A message that is received again, e.g. after a reconnect or a NACK, is
+// tracked once per delivery, in the order it was read.
+//
+// If it was created with a limit, a slot has to be reserved before reading a
+// message, which blocks while the limit of deliveries is tracked.
+//
+// In ordered mode, the deliveries of each subscription are additionally kept
+// in read order, so that cumulative acks only cover messages acked by Conduit.
+type inFlight struct {
+	mu         sync.Mutex
+	deliveries map[string][]*delivery
+	count      int
+
+	// ordered holds the deliveries of each subscription in read order, it is
+	// nil if the deliveries are not ordered.
+	ordered map[*stomp.Subscription][]*delivery
+
+	// slots holds a value per reserved or tracked delivery, it is nil if the
+	// number of deliveries is not limited.
+	slots chan struct{}
+}
+
+// newInFlight creates an inFlight that tracks at most limit deliveries. A limit
+// of 0 means no limit.
+func newInFlight(limit int, ordered bool) *inFlight {
+	f := &inFlight{deliveries: make(map[string][]*delivery)}
+	if limit > 0 {
+		f.slots = make(chan struct{}, limit)
+	}
+	if ordered {
+		f.ordered = make(map[*stomp.Subscription][]*delivery)
+	}
+	return f
+}
+
+// reserve blocks until a delivery can be added or the context is cancelled.
+// The reserved slot is taken by the next call to add, or freed by cancel.
+func (f *inFlight) reserve(ctx context.Context) error {
+	if f.slots == nil {
+		return nil
+	}
+
+	select {
+	case f.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("context error while waiting for acks: %w", ctx.Err())
+	}
+}
+
+// tryReserve reserves a slot if one is available without blocking.
+func (f *inFlight) tryReserve() bool {
+	if f.slots == nil {
+		return true
+	}
+
+	select {
+	case f.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancel frees a slot that was reserved but not used.
+func (f *inFlight) cancel() {
+	if f.slots != nil {
+		<-f.slots
+	}
+}
+
+// add tracks a delivery, using a slot reserved before.
+func (f *inFlight) add(messageID string, d *delivery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deliveries[messageID] = append(f.deliveries[messageID], d)
+	f.count++
+
+	if f.ordered != nil {
+		sub := d.msg.Subscription
+		f.ordered[sub] = append(f.ordered[sub], d)
+	}
+}
+
+// pop removes and returns the oldest delivery of the message with the given ID.
+func (f *inFlight) pop(messageID string) (*delivery, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deliveries := f.deliveries[messageID]
+	if len(deliveries) == 0 {
+		return nil, false
+	}
+
+	if len(deliveries) == 1 {
+		delete(f.deliveries, messageID)
+	} else {
+		f.deliveries[messageID] = deliveries[1:]
+	}
+	f.count--
+	f.cancel()
+
+	return deliveries[0], true
+}
+
+// ackOrdered marks a popped delivery as acked and removes the acked deliveries
+// at the start of its subscription. It returns the last removed delivery, which
+// can be acked cumulatively, or nil if an earlier delivery is not acked yet.
+func (f *inFlight) ackOrdered(d *delivery) *delivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	d.acked = true
+
+	sub := d.msg.Subscription
+	deliveries := f.ordered[sub]
+	n := 0
+	for n < len(deliveries) && deliveries[n].acked {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	last := deliveries[n-1]
+	if n == len(deliveries) {
+		delete(f.ordered, sub)
+	} else {
+		f.ordered[sub] = deliveries[n:]
+	}
+
+	return last
+}
+
+// stats returns the number of tracked deliveries and the age of the oldest one.
+func (f *inFlight) stats(now time.Time) (count int, oldestAge time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, deliveries := range f.deliveries {
+		for _, d := range deliveries {
+			oldestAge = max(oldestAge, now.Sub(d.readAt))
+		}
+	}
+
+	return f.count, oldestAge
+}
+
+// expire marks all deliveries read before the given time as nacked and returns
+// them. Expired deliveries stay tracked until they are acked, so that the
+// ack can be skipped.
+func (f *inFlight) expire(readBefore time.Time) []*delivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var expired []*delivery
+	for _, deliveries := range f.deliveries {
+		for _, d := range deliveries {
+			if !d.nacked && d.readAt.Before(readBefore) {
+				d.nacked = true
+				expired = append(expired, d)
+			}
+		}
+	}
+
+	return expired
+}
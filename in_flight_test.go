@@ -0,0 +1,123 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/matryer/is"
+)
+
+func TestInFlightPop(t *testing.T) {
+	is := is.New(t)
+
+	f := newInFlight(0, false)
+	first, second := &delivery{}, &delivery{}
+	f.add("id-1", first)
+	f.add("id-1", second)
+
+	d, ok := f.pop("id-1")
+	is.True(ok)
+	is.Equal(d, first)
+
+	d, ok = f.pop("id-1")
+	is.True(ok)
+	is.Equal(d, second)
+
+	_, ok = f.pop("id-1")
+	is.True(!ok)
+}
+
+func TestInFlightExpire(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	f := newInFlight(0, false)
+	old := &delivery{readAt: now.Add(-time.Minute)}
+	recent := &delivery{readAt: now}
+	f.add("id-1", old)
+	f.add("id-2", recent)
+
+	expired := f.expire(now.Add(-time.Second))
+	is.Equal(len(expired), 1)
+	is.Equal(expired[0], old)
+	is.True(old.nacked)
+	is.True(!recent.nacked)
+
+	// Deliveries are only expired once.
+	is.Equal(len(f.expire(now.Add(-time.Second))), 0)
+
+	// Expired deliveries are still tracked until they are acked.
+	d, ok := f.pop("id-1")
+	is.True(ok)
+	is.True(d.nacked)
+}
+
+func TestInFlightLimit(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	f := newInFlight(1, false)
+	is.NoErr(f.reserve(ctx))
+	f.add("id-1", &delivery{readAt: time.Now().Add(-time.Minute)})
+
+	count, oldestAge := f.stats(time.Now())
+	is.Equal(count, 1)
+	is.True(oldestAge >= time.Minute)
+
+	is.True(!f.tryReserve())
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	is.True(f.reserve(ctx) != nil)
+
+	_, ok := f.pop("id-1")
+	is.True(ok)
+	is.True(f.tryReserve())
+	f.cancel()
+	is.True(f.tryReserve())
+}
+
+func TestInFlightAckOrdered(t *testing.T) {
+	is := is.New(t)
+
+	f := newInFlight(0, true)
+	sub1, sub2 := &stomp.Subscription{}, &stomp.Subscription{}
+	deliveries := map[string]*delivery{
+		"a": {msg: &stomp.Message{Subscription: sub1}},
+		"b": {msg: &stomp.Message{Subscription: sub1}},
+		"c": {msg: &stomp.Message{Subscription: sub2}},
+		"d": {msg: &stomp.Message{Subscription: sub1}},
+	}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		f.add(id, deliveries[id])
+	}
+
+	ack := func(id string) *delivery {
+		d, ok := f.pop(id)
+		is.True(ok)
+		return f.ackOrdered(d)
+	}
+
+	// b can't be acked before a.
+	is.Equal(ack("b"), nil)
+	// Subscriptions are ordered independently.
+	is.Equal(ack("c"), deliveries["c"])
+	// Acking a acks b as well.
+	is.Equal(ack("a"), deliveries["b"])
+	is.Equal(ack("d"), deliveries["d"])
+}
@@ -19,14 +19,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/conduitio/conduit-commons/opencdc"
 	sdk "github.com/conduitio/conduit-connector-sdk"
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/goccy/go-json"
-	cmap "github.com/orcaman/concurrent-map/v2"
 )
 
 type SourceConfig struct {
@@ -34,6 +37,24 @@ type SourceConfig struct {
 
 	Config
 
+	// The names of the queues (or topics, see destinationType) to read from,
+	// separated by commas. ActiveMQ wildcards such as orders.> are supported.
+	Queues []string `json:"queue" validate:"required"`
+
+	// How messages are read, one of consume or browse. In browse mode the
+	// queues are read without consuming the messages, one after another, and
+	// emitted as snapshot records. The snapshot is finished once all messages
+	// that were on the queues were read. Browse mode only supports queues.
+	Mode string `json:"mode" default:"consume" validate:"inclusion=consume|browse"`
+
+	// The type of destination to consume from, one of queue, topic or virtualTopic.
+	// When consuming from a virtual topic, the connector subscribes to the
+	// Consumer.<consumerName>.VirtualTopic.<queue> queue.
+	DestinationType string `json:"destinationType" default:"queue" validate:"inclusion=queue|topic|virtualTopic"`
+
+	// The name of the consumer when consuming from a virtual topic.
+	ConsumerName string `json:"consumerName"`
+
 	// The JMS clientID which is used in combination with
 	// the activemq.subcriptionName to denote a durable subscriber.
 	// Maps to the client-id header.
@@ -61,6 +82,7 @@ type SourceConfig struct {
 	// The maximum number of messages to dispatch to the client before it acknowledges a message.
 	// No further messages are dispatched once this limit is hit.
 	// For fair message distribution across consumers, consider setting this to a value greater than 1.
+	// Defaults to maxInFlight if that is set and must not be greater than it.
 	// Maps to the activemq.prefetchSize header.
 	PrefetchSize int `json:"activemq.prefetchSize"`
 
@@ -82,25 +104,271 @@ type SourceConfig struct {
 	// enabling a filter to be applied on each message associated with the subscription.
 	// Maps to the selector header.
 	Selector string `json:"selector"`
+
+	// The acknowledgement mode, one of client-individual, client or auto. In
+	// client-individual mode every message is acked on its own. In client mode
+	// an ack covers all earlier messages of the subscription, so messages are
+	// only acked once Conduit acked them and all messages read before them. In
+	// auto mode the broker doesn't wait for acks, messages that weren't
+	// processed yet are lost when the connector stops.
+	AckMode string `json:"ackMode" default:"client-individual" validate:"inclusion=client-individual|client|auto"`
+
+	// Whether to decode message bodies that contain an OpenCDC record
+	// serialized as JSON, e.g. written by this connector's destination, back
+	// into the original record. One of never, detect or always. In detect mode
+	// messages with the content type application/json are decoded if they
+	// contain an OpenCDC record, other messages are read as raw data. In always
+	// mode reading fails if a message doesn't contain an OpenCDC record. The
+	// collection and activemq.* metadata are taken from the read message, and
+	// key.* is applied to decoded records. Can't be used with
+	// operation.header, decoded records keep their operation.
+	DecodeOpenCDC string `json:"decodeOpenCDC" default:"never" validate:"inclusion=never|detect|always"`
+
+	// The format of the message bodies, one of raw, auto, json, xml or csv.
+	// Bodies in the json, xml or csv format are parsed into structured data.
+	// In auto mode the format is taken from the content-type header of each
+	// message, bodies with an unknown content type are read as raw data.
+	PayloadFormat string `json:"payload.format" default:"raw" validate:"inclusion=raw|auto|json|xml|csv"`
+
+	// The column names of csv bodies. If empty, the first line of each body
+	// is used as the header. Each body must contain a single row.
+	PayloadCSVHeader []string `json:"payload.csvHeader"`
+
+	// What to do with messages whose body can't be parsed in the payload
+	// format, one of raw, nack or error. raw reads the body as raw data, nack
+	// negatively acknowledges the message, which makes the broker move it to
+	// the dead letter queue, and error stops the pipeline.
+	PayloadParseErrorPolicy string `json:"payload.parseErrorPolicy" default:"raw" validate:"inclusion=raw|nack|error"`
+
+	// The STOMP header to use as the record key, e.g. JMSXGroupID or
+	// correlation-id. By default, the message ID is used as the key. It is
+	// also used for messages without the header.
+	KeyHeader string `json:"key.header"`
+
+	// A JSON path into the message body to use as the record key, e.g.
+	// $.order.id or items[0].sku. String values are used as raw data, objects
+	// as structured data and other values as their JSON representation. If
+	// the path is not found, the message ID is used as the key.
+	KeyJSONPath string `json:"key.jsonPath"`
+
+	// A Go template that is evaluated for each record to get the record key,
+	// e.g. {{ index .Metadata "activemq.header.correlation-id" }}. Sprig
+	// functions are available in the template.
+	KeyTemplate string `json:"key.template"`
+
+	// Whether to copy all STOMP headers into the metadata, with keys prefixed
+	// as activemq.header.{STOMP_HEADER_NAME}. The well-known headers are
+	// always available under stable keys such as activemq.correlationId.
+	MetadataRawHeaders bool `json:"metadata.rawHeaders" default:"true"`
+
+	// How headers are stored in the metadata when metadata.rawHeaders is
+	// enabled, one of joined or json. joined stores each header under
+	// activemq.header.{STOMP_HEADER_NAME}, with repeated values joined by a
+	// comma and a space. json stores all headers in activemq.headers as a JSON
+	// object mapping each header name to the list of its values, which keeps
+	// repeated values and values containing commas intact.
+	MetadataHeaderEncoding string `json:"metadata.headerEncoding" default:"joined" validate:"inclusion=joined|json"`
+
+	Operation OperationConfig `json:"operation"`
+
+	// The maximum amount of time a read message can stay unacknowledged by
+	// Conduit. Once it expires, the message is negatively acknowledged (NACK).
+	// ActiveMQ classic treats a STOMP NACK as a poison ack and moves the
+	// message to the dead letter queue without redelivering it, so a short
+	// timeout dead-letters messages that are just slow to be processed. 0
+	// disables the timeout. Only supported with ackMode client-individual.
+	AckTimeout time.Duration `json:"ackTimeout" default:"0" validate:"gt=-1"`
+
+	// The maximum number of read messages that are waiting to be acked by
+	// Conduit. Once it is reached, reading blocks until messages are acked.
+	// 0 means no limit. Not supported with ackMode auto.
+	MaxInFlight int `json:"maxInFlight" default:"0" validate:"gt=-1"`
+
+	// How often the number of read messages waiting to be acked and the age
+	// of the oldest of them are logged at info level. 0 or a negative value
+	// disables the log.
+	InFlightLogInterval time.Duration `json:"inFlightLogInterval" default:"1m"`
+}
+
+const (
+	destinationTypeQueue        = "queue"
+	destinationTypeTopic        = "topic"
+	destinationTypeVirtualTopic = "virtualTopic"
+)
+
+const (
+	ackModeClientIndividual = "client-individual"
+	ackModeClient           = "client"
+	ackModeAuto             = "auto"
+)
+
+type OperationConfig struct {
+	// The STOMP header that contains the operation of a message, e.g. op.
+	// Messages without the header are read as create records.
+	Header string `json:"header"`
+
+	// Maps values of operation.header to operations, e.g.
+	// operation.mapping.I: create. The operation is one of create, update,
+	// delete or snapshot. Values that are operation names map to that
+	// operation, reading fails for other values that are not mapped.
+	Mapping map[string]string `json:"mapping"`
+}
+
+func (c *SourceConfig) Validate(ctx context.Context) error {
+	errs := []error{
+		c.DefaultSourceMiddleware.Validate(ctx),
+		c.Config.Validate(ctx),
+	}
+
+	switch c.DestinationType {
+	case destinationTypeTopic:
+		if (c.ClientID == "") != (c.SubscriptionName == "") {
+			errs = append(errs, errors.New(
+				"durable topic subscriptions require both clientID and activemq.subscriptionName to be set"))
+		}
+	case destinationTypeVirtualTopic:
+		if c.ConsumerName == "" {
+			errs = append(errs, errors.New("consumerName is required when consuming from a virtual topic"))
+		}
+	}
+
+	if c.Mode == modeBrowse {
+		if c.DestinationType != destinationTypeQueue {
+			errs = append(errs, errors.New("mode browse only supports destinationType queue"))
+		}
+		if c.AckTimeout > 0 || c.MaxInFlight > 0 || c.PayloadParseErrorPolicy == parseErrorPolicyNack {
+			errs = append(errs, errors.New(
+				"ackTimeout, maxInFlight and payload.parseErrorPolicy nack are not supported with mode browse"))
+		}
+	}
+
+	if c.AckTimeout > 0 && c.AckMode != ackModeClientIndividual {
+		errs = append(errs, fmt.Errorf("ackTimeout is not supported with ackMode %v", c.AckMode))
+	}
+
+	if c.PayloadParseErrorPolicy == parseErrorPolicyNack && c.AckMode != ackModeClientIndividual {
+		errs = append(errs, fmt.Errorf("payload.parseErrorPolicy nack is not supported with ackMode %v", c.AckMode))
+	}
+
+	if (c.AckTimeout > 0 || c.PayloadParseErrorPolicy == parseErrorPolicyNack) && !c.supportsNack() {
+		errs = append(errs, errors.New(
+			"ackTimeout and payload.parseErrorPolicy nack require STOMP 1.1 or later in acceptVersions"))
+	}
+
+	if c.DecodeOpenCDC != decodeOpenCDCNever && c.Operation.Header != "" {
+		errs = append(errs, errors.New("operation.header can't be used with decodeOpenCDC detect or always"))
+	}
+
+	if c.MaxInFlight > 0 && c.AckMode == ackModeAuto {
+		errs = append(errs, errors.New("maxInFlight is not supported with ackMode auto"))
+	}
+
+	keySources := 0
+	for _, v := range []string{c.KeyHeader, c.KeyJSONPath, c.KeyTemplate} {
+		if v != "" {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		errs = append(errs, errors.New("only one of key.header, key.jsonPath and key.template can be set"))
+	}
+	if c.KeyTemplate != "" {
+		if _, err := parseTemplate("key", c.KeyTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for value, operation := range c.Operation.Mapping {
+		if _, ok := parseOperation(operation); !ok {
+			errs = append(errs, fmt.Errorf("invalid operation %q for operation.mapping.%v", operation, value))
+		}
+	}
+
+	if c.MaxInFlight > 0 && c.PrefetchSize > c.MaxInFlight {
+		errs = append(errs, fmt.Errorf(
+			"activemq.prefetchSize (%d) must not be greater than maxInFlight (%d)", c.PrefetchSize, c.MaxInFlight))
+	}
+
+	return errors.Join(errs...)
+}
+
+// acksMessages reports whether messages are acked to the broker.
+func (c SourceConfig) acksMessages() bool {
+	return c.Mode != modeBrowse && c.AckMode != ackModeAuto
+}
+
+// stompAckMode returns the STOMP ack mode of the subscriptions.
+func (c SourceConfig) stompAckMode() stomp.AckMode {
+	switch c.AckMode {
+	case ackModeClient:
+		return stomp.AckClient
+	case ackModeAuto:
+		return stomp.AckAuto
+	default:
+		return stomp.AckClientIndividual
+	}
+}
+
+// destination builds the STOMP destination to subscribe to from a configured
+// queue and the destination type.
+func (c SourceConfig) destination(queue string) string {
+	switch c.DestinationType {
+	case destinationTypeTopic:
+		return "/topic/" + queue
+	case destinationTypeVirtualTopic:
+		topic := queue
+		if !strings.HasPrefix(topic, "VirtualTopic.") {
+			topic = "VirtualTopic." + topic
+		}
+		return "/queue/Consumer." + c.ConsumerName + "." + topic
+	default:
+		if strings.HasPrefix(queue, "/") {
+			// The queue name already contains a destination prefix.
+			return queue
+		}
+		return "/queue/" + queue
+	}
 }
 
 type Source struct {
 	sdk.UnimplementedSource
 	config SourceConfig
 
-	conn         *stomp.Conn
-	subscription *stomp.Subscription
+	conn *connManager
+	// subscriptions holds the subscription of each configured queue on the
+	// current connection.
+	subscriptions map[string]*stomp.Subscription
+	// received fans in the messages of all subscriptions.
+	received chan receivedMessage
+	// done is closed on teardown to stop forwarding messages into received.
+	done chan struct{}
 
-	storedMessages cmap.ConcurrentMap[string, *stomp.Message]
+	// inFlight holds the messages that are waiting to be acked.
+	inFlight *inFlight
+
+	keyTemplate *template.Template
+
+	// browse holds the progress of the snapshot in browse mode.
+	browse browseState
 }
 
 func (s *Source) Config() sdk.SourceConfig {
 	return &s.config
 }
 
+// receivedMessage is a message received on the subscription of a queue. A nil
+// msg means that the subscription channel was closed.
+type receivedMessage struct {
+	queue        string
+	subscription *stomp.Subscription
+	msg          *stomp.Message
+}
+
 func NewSource() sdk.Source {
 	return sdk.SourceWithMiddleware(&Source{
-		storedMessages: cmap.New[*stomp.Message](),
+		subscriptions: make(map[string]*stomp.Subscription),
+		received:      make(chan receivedMessage),
+		done:          make(chan struct{}),
 	})
 }
 
@@ -130,9 +398,14 @@ func getSubscribeOpts(config SourceConfig) []func(*frame.Frame) error {
 		addHeader("activemq.noLocal", "true")
 	}
 
-	if config.PrefetchSize > 0 {
+	switch {
+	case config.PrefetchSize > 0:
 		value := fmt.Sprint(config.PrefetchSize)
 		addHeader("activemq.prefetchSize", value)
+	case config.MaxInFlight > 0:
+		// Don't let the broker dispatch messages that can't be read anyway.
+		value := fmt.Sprint(config.MaxInFlight)
+		addHeader("activemq.prefetchSize", value)
 	}
 
 	if config.Priority > 0 {
@@ -155,35 +428,71 @@ func getSubscribeOpts(config SourceConfig) []func(*frame.Frame) error {
 	return opts
 }
 
-func (s *Source) Open(ctx context.Context, sdkPos opencdc.Position) (err error) {
-	s.conn, err = connectSource(ctx, s.config)
-	if err != nil {
-		return fmt.Errorf("failed to dial to ActiveMQ: %w", err)
-	}
-
+func (s *Source) Open(ctx context.Context, sdkPos opencdc.Position) error {
 	if sdkPos != nil {
 		pos, err := parseSDKPosition(sdkPos)
 		if err != nil {
 			return fmt.Errorf("failed to parse position: %w", err)
 		}
 
-		if s.config.Queue != "" && s.config.Queue != pos.Queue {
+		if !slices.Contains(s.config.Queues, pos.Queue) {
+			return fmt.Errorf(
+				"the old position contains a queue name that is not in the connector configuration (%q not in %q), please check if the configured queue names changed since the last run",
+				pos.Queue, s.config.Queues,
+			)
+		}
+
+		// Positions written before the destination type was recorded always
+		// belong to a queue.
+		posDestinationType := pos.DestinationType
+		if posDestinationType == "" {
+			posDestinationType = destinationTypeQueue
+		}
+		if posDestinationType != s.config.DestinationType {
 			return fmt.Errorf(
-				"the old position contains a different queue name than the connector configuration (%q vs %q), please check if the configured queue name changed since the last run",
-				pos.Queue, s.config.Queue,
+				"the old position contains a different destination type than the connector configuration (%q vs %q), please check if the configured destination type changed since the last run",
+				posDestinationType, s.config.DestinationType,
 			)
 		}
 
-		s.config.Queue = pos.Queue
-		sdk.Logger(ctx).Debug().Str("queue", pos.Queue).Msg("got queue name from given position")
+		if s.config.Mode == modeBrowse && pos.Browse {
+			// Resume the snapshot after the last browsed message.
+			s.browse.queue = slices.Index(s.config.Queues, pos.Queue)
+			s.browse.lastMessageID = pos.MessageID
+		}
 	}
 
-	subscribeOpts := getSubscribeOpts(s.config)
-	s.subscription, err = s.conn.Subscribe(
-		s.config.Queue, stomp.AckClientIndividual,
-		subscribeOpts...)
+	if s.config.KeyTemplate != "" {
+		var err error
+		s.keyTemplate, err = parseTemplate("key", s.config.KeyTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	url, err := parseBrokerURL(s.config.URL, s.config.Reconnect)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe to queue: %w", err)
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+	url.warnIgnoredOptions(ctx)
+
+	s.inFlight = newInFlight(s.config.MaxInFlight, s.config.AckMode == ackModeClient)
+	s.conn = newConnManager(
+		url,
+		func(ctx context.Context, broker brokerAddr) (*stomp.Conn, error) {
+			return connectSource(ctx, s.config, broker)
+		},
+		s.subscribe,
+	)
+	if err := s.conn.Open(ctx); err != nil {
+		return fmt.Errorf("failed to dial to ActiveMQ: %w", err)
+	}
+
+	if s.config.AckTimeout > 0 {
+		go s.nackExpired(ctx)
+	}
+	if s.config.InFlightLogInterval > 0 && s.config.acksMessages() {
+		go s.logInFlight(ctx)
 	}
 
 	sdk.Logger(ctx).Debug().Msg("opened source")
@@ -191,46 +500,177 @@ func (s *Source) Open(ctx context.Context, sdkPos opencdc.Position) (err error)
 	return nil
 }
 
-func (s *Source) Read(ctx context.Context) (opencdc.Record, error) {
-	var rec opencdc.Record
+// subscribe subscribes to all configured destinations on the given
+// connection. It is called on the initial connection and again after every
+// reconnect.
+func (s *Source) subscribe(ctx context.Context, conn *stomp.Conn) error {
+	if s.config.Mode == modeBrowse {
+		return s.subscribeBrowser(ctx, conn)
+	}
+
+	subscribeOpts := getSubscribeOpts(s.config)
+	for _, queue := range s.config.Queues {
+		destination := s.config.destination(queue)
+		sub, err := conn.Subscribe(destination, s.config.stompAckMode(), subscribeOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %v: %w", destination, err)
+		}
+		sdk.Logger(ctx).Debug().Str("destination", destination).Msg("subscribed to destination")
 
-	select {
-	case <-ctx.Done():
-		if err := ctx.Err(); err != nil {
-			return rec, fmt.Errorf("context error: %w", err)
+		s.subscriptions[queue] = sub
+		go s.forward(queue, sub)
+	}
+
+	return nil
+}
+
+// forward sends all messages of a subscription to the received channel,
+// followed by a receivedMessage without a message once the subscription
+// channel is closed.
+func (s *Source) forward(queue string, sub *stomp.Subscription) {
+	send := func(msg *stomp.Message) bool {
+		select {
+		case s.received <- receivedMessage{queue: queue, subscription: sub, msg: msg}:
+			return true
+		case <-s.done:
+			return false
 		}
+	}
 
-		return rec, nil
-	case msg, ok := <-s.subscription.C:
-		if !ok {
-			return rec, errors.New("source message channel closed")
+	for msg := range sub.C {
+		if !send(msg) {
+			return
 		}
+	}
+	send(nil)
+}
 
-		if err := msg.Err; err != nil {
-			return rec, fmt.Errorf("source message error: %w", err)
+func (s *Source) Read(ctx context.Context) (opencdc.Record, error) {
+	if !s.inFlight.tryReserve() {
+		count, oldestAge := s.inFlight.stats(time.Now())
+		sdk.Logger(ctx).Debug().
+			Int("inFlight", count).
+			Dur("oldestUnackedAge", oldestAge).
+			Msg("maximum number of in-flight messages reached, waiting for acks")
+
+		if err := s.inFlight.reserve(ctx); err != nil {
+			return opencdc.Record{}, err
 		}
+	}
 
-		var (
-			messageID = msg.Header.Get(frame.MessageId)
-			pos       = Position{
-				MessageID: messageID,
-				Queue:     s.config.Queue,
+	rec, err := s.read(ctx)
+	if err != nil {
+		s.inFlight.cancel()
+	}
+
+	return rec, err
+}
+
+// read reads the next message, using the in-flight slot reserved by Read.
+func (s *Source) read(ctx context.Context) (opencdc.Record, error) {
+	var rec opencdc.Record
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err != nil {
+				return rec, fmt.Errorf("context error: %w", err)
 			}
-			sdkPos   = pos.ToSdkPosition()
-			metadata = metadataFromMsg(msg)
-			key      = opencdc.RawData(messageID)
-			payload  = opencdc.RawData(msg.Body)
-		)
 
-		rec = sdk.Util.Source.NewRecordCreate(sdkPos, metadata, key, payload)
+			return rec, nil
+		case received := <-s.received:
+			if s.subscriptions[received.queue] != received.subscription {
+				// The subscription belongs to a connection that was already
+				// replaced, its unacked messages are redelivered on the new one.
+				continue
+			}
 
-		sdk.Logger(ctx).Trace().Str("queue", s.config.Queue).Msgf("read message")
-		s.storedMessages.Set(messageID, msg)
+			msg := received.msg
+			var subErr error
+			switch {
+			case msg == nil:
+				subErr = errors.New("source message channel closed")
+			case msg.Err != nil:
+				subErr = fmt.Errorf("source message error: %w", msg.Err)
+			}
+
+			if subErr != nil {
+				if !s.config.Reconnect.Enabled {
+					return rec, subErr
+				}
 
-		return rec, nil
+				sdk.Logger(ctx).Warn().Err(subErr).Str("queue", received.queue).Msg("lost connection to ActiveMQ")
+				if _, err := s.conn.Reconnect(ctx); err != nil {
+					return rec, fmt.Errorf("%w: %w", subErr, err)
+				}
+				continue
+			}
+
+			if s.config.Mode == modeBrowse {
+				ok, err := s.browseMessage(ctx, received.queue, msg)
+				if err != nil {
+					return rec, err
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			messageID := msg.Header.Get(frame.MessageId)
+			pos := Position{
+				MessageID:       messageID,
+				Queue:           received.queue,
+				DestinationType: s.config.DestinationType,
+				Browse:          s.config.Mode == modeBrowse,
+			}
+
+			rec, err := s.newRecord(ctx, msg, pos.ToSdkPosition())
+			if errors.Is(err, errInvalidPayload) && s.config.PayloadParseErrorPolicy == parseErrorPolicyNack {
+				if err := msg.Conn.Nack(msg); err != nil {
+					return rec, fmt.Errorf("failed to nack message: %w", err)
+				}
+				sdk.Logger(ctx).Warn().Err(err).Str("queue", received.queue).Msg("nacked message with invalid payload")
+				continue
+			}
+			if err != nil {
+				return rec, err
+			}
+
+			sdk.Logger(ctx).Trace().Str("queue", received.queue).Msgf("read message")
+			if s.config.acksMessages() {
+				s.inFlight.add(messageID, &delivery{msg: msg, readAt: time.Now()})
+			}
+
+			return rec, nil
+		}
+	}
+}
+
+// collectionFromDestination strips the destination type prefix from the
+// destination a message was sent to. When subscribing with a wildcard, this is
+// the actual queue or topic the message originates from.
+func collectionFromDestination(destination string) string {
+	for _, prefix := range []string{"/queue/", "/topic/"} {
+		if name, ok := strings.CutPrefix(destination, prefix); ok {
+			return name
+		}
 	}
+	return destination
 }
 
+// activemqMetadataPrefix is the prefix of all metadata keys set from the read
+// message.
+const activemqMetadataPrefix = "activemq."
+
+// metadataHeaderPrefix is the prefix of metadata keys that hold STOMP headers.
+const metadataHeaderPrefix = "activemq.header."
+
+// metadataHeaders is the metadata key that holds all STOMP headers encoded as
+// a JSON object of header names to values.
+const metadataHeaders = "activemq.headers"
+
+const headerEncodingJSON = "json"
+
 // metadataFromMsg extracts all the present headers from a stomp.Message into
 // opencdc.Metadata.
 func metadataFromMsg(msg *stomp.Message) opencdc.Metadata {
@@ -240,7 +680,7 @@ func metadataFromMsg(msg *stomp.Message) opencdc.Metadata {
 		k, v := msg.Header.GetAt(i)
 
 		// Prefix to avoid collisions with other metadata keys
-		headerKey := "activemq.header." + k
+		headerKey := metadataHeaderPrefix + k
 
 		// According to the STOMP protocol, headers can have multiple values for
 		// the same key. We concatenate them with a comma and a space.
@@ -260,35 +700,211 @@ func metadataFromMsg(msg *stomp.Message) opencdc.Metadata {
 	return metadata
 }
 
+// metadataHeadersJSON encodes all headers of a message as a JSON object,
+// keeping repeated values in the order they were received.
+func metadataHeadersJSON(msg *stomp.Message) string {
+	values := make(map[string][]string)
+	for i := range msg.Header.Len() {
+		k, v := msg.Header.GetAt(i)
+		values[k] = append(values[k], v)
+	}
+
+	bs, err := json.Marshal(values)
+	if err != nil {
+		// this should never happen
+		panic(err)
+	}
+
+	return string(bs)
+}
+
+// Metadata keys of the well-known STOMP and JMS headers.
+const (
+	metadataDestination   = "activemq.destination"
+	metadataMessageID     = "activemq.messageId"
+	metadataPriority      = "activemq.priority"
+	metadataExpires       = "activemq.expires"
+	metadataRedelivered   = "activemq.redelivered"
+	metadataDeliveryCount = "activemq.deliveryCount"
+	metadataCorrelationID = "activemq.correlationId"
+	metadataReplyTo       = "activemq.replyTo"
+	metadataType          = "activemq.type"
+)
+
+// setMessageMetadata sets the metadata of the well-known headers of a message,
+// converted to stable formats. Headers that are not present are skipped, except
+// for redelivered, which is always set. The JMS timestamp is stored as the
+// creation time of the record, the expiration time is formatted as RFC 3339.
+func setMessageMetadata(msg *stomp.Message, metadata opencdc.Metadata) {
+	setString := func(key, header string) {
+		if value := msg.Header.Get(header); value != "" {
+			metadata[key] = value
+		}
+	}
+	setInt := func(key, header string) {
+		if value, err := strconv.ParseInt(msg.Header.Get(header), 10, 64); err == nil {
+			metadata[key] = strconv.FormatInt(value, 10)
+		}
+	}
+
+	if msg.Destination != "" {
+		metadata[metadataDestination] = msg.Destination
+	}
+	setString(metadataMessageID, frame.MessageId)
+	setInt(metadataPriority, "priority")
+	setInt(metadataDeliveryCount, "JMSXDeliveryCount")
+	setString(metadataCorrelationID, "correlation-id")
+	setString(metadataReplyTo, "reply-to")
+	setString(metadataType, "type")
+
+	redelivered, _ := strconv.ParseBool(msg.Header.Get("redelivered"))
+	metadata[metadataRedelivered] = strconv.FormatBool(redelivered)
+
+	// JMS timestamps are milliseconds since the epoch, 0 means not set.
+	if ms, err := strconv.ParseInt(msg.Header.Get("timestamp"), 10, 64); err == nil && ms > 0 {
+		metadata.SetCreatedAt(time.UnixMilli(ms))
+	}
+	if ms, err := strconv.ParseInt(msg.Header.Get("expires"), 10, 64); err == nil && ms > 0 {
+		metadata[metadataExpires] = time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+	}
+}
+
 func (s *Source) Ack(ctx context.Context, position opencdc.Position) error {
 	pos, err := parseSDKPosition(position)
 	if err != nil {
 		return fmt.Errorf("failed to parse position: %w", err)
 	}
 
-	msg, ok := s.storedMessages.Get(pos.MessageID)
+	if !s.config.acksMessages() {
+		// The broker doesn't expect acks.
+		return nil
+	}
+
+	d, ok := s.inFlight.pop(pos.MessageID)
 	if !ok {
 		return fmt.Errorf("message with ID %q not found", pos.MessageID)
 	}
 
-	if err := s.conn.Ack(msg); err != nil {
-		return fmt.Errorf("failed to ack message: %w", err)
+	if d.nacked {
+		// The message was already handed back to the broker, which moved it
+		// to the dead letter queue.
+		sdk.Logger(ctx).Warn().
+			Str("queue", pos.Queue).
+			Str("messageID", pos.MessageID).
+			Msg("message was negatively acknowledged after the ack timeout, skipping ack")
+		return nil
+	}
+
+	msg := d.msg
+	if s.config.AckMode == ackModeClient {
+		// Acks are cumulative, so we can only ack the last message of the
+		// prefix that Conduit acked completely.
+		last := s.inFlight.ackOrdered(d)
+		if last == nil {
+			sdk.Logger(ctx).Trace().Str("queue", pos.Queue).Msg("waiting for earlier messages to be acked")
+			return nil
+		}
+		msg = last.msg
+	}
+
+	if msg.Conn != s.conn.Conn() {
+		// The message was received on a connection that has since been
+		// replaced. The broker redelivers it on the new connection, where it
+		// is acked again.
+		sdk.Logger(ctx).Warn().
+			Str("queue", pos.Queue).
+			Str("messageID", pos.MessageID).
+			Msg("message was received on a previous connection, skipping ack")
+		return nil
 	}
 
-	s.storedMessages.Remove(pos.MessageID)
+	if err := msg.Conn.Ack(msg); err != nil {
+		return fmt.Errorf("failed to ack message: %w", err)
+	}
 
-	sdk.Logger(ctx).Trace().Str("queue", s.config.Queue).Msgf("acked message")
+	sdk.Logger(ctx).Trace().Str("queue", pos.Queue).Msgf("acked message")
 
 	return nil
 }
 
+// nackExpired negatively acknowledges the messages that weren't acked within
+// the ack timeout, until the source is torn down.
+func (s *Source) nackExpired(ctx context.Context) {
+	// Check twice per timeout, so that messages are nacked at most half a
+	// timeout late.
+	ticker := time.NewTicker(max(s.config.AckTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		conn := s.conn.Conn()
+		for _, d := range s.inFlight.expire(time.Now().Add(-s.config.AckTimeout)) {
+			messageID := d.msg.Header.Get(frame.MessageId)
+			if d.msg.Conn != conn {
+				// Messages received on a previous connection are redelivered anyway.
+				continue
+			}
+
+			if err := d.msg.Conn.Nack(d.msg); err != nil {
+				sdk.Logger(ctx).Warn().Err(err).Str("messageID", messageID).Msg("failed to nack message")
+				continue
+			}
+			sdk.Logger(ctx).Warn().
+				Str("messageID", messageID).
+				Dur("ackTimeout", s.config.AckTimeout).
+				Msg("message was not acked in time, nacked message")
+		}
+	}
+}
+
+// logInFlight periodically logs the number of in-flight messages and the age
+// of the oldest one, until the source is torn down. Conduit connectors have no
+// metrics API, so this is how operators can watch them.
+func (s *Source) logInFlight(ctx context.Context) {
+	ticker := time.NewTicker(s.config.InFlightLogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		count, oldestAge := s.inFlight.stats(time.Now())
+		sdk.Logger(ctx).Info().
+			Int("inFlight", count).
+			Int("maxInFlight", s.config.MaxInFlight).
+			Dur("oldestUnackedAge", oldestAge).
+			Msg("in-flight messages")
+	}
+}
+
 func (s *Source) Teardown(ctx context.Context) error {
-	return teardown(ctx, s.subscription, s.conn)
+	close(s.done)
+
+	if s.inFlight != nil {
+		if count, oldestAge := s.inFlight.stats(time.Now()); count > 0 {
+			sdk.Logger(ctx).Info().
+				Int("inFlight", count).
+				Dur("oldestUnackedAge", oldestAge).
+				Msg("tearing down with unacked messages, the broker will redeliver them")
+		}
+	}
+	return teardown(ctx, s.subscriptions, s.conn)
 }
 
 type Position struct {
-	MessageID string `json:"message_id"`
-	Queue     string `json:"queue"`
+	MessageID       string `json:"message_id"`
+	Queue           string `json:"queue"`
+	DestinationType string `json:"destination_type,omitempty"`
+	// Browse is set for positions of messages that were read in browse mode.
+	Browse bool `json:"browse,omitempty"`
 }
 
 func parseSDKPosition(sdkPos opencdc.Position) (Position, error) {
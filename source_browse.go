@@ -0,0 +1,127 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+const (
+	modeConsume = "consume"
+	modeBrowse  = "browse"
+)
+
+// browserHeader is the subscribe header that turns a subscription into a queue
+// browser. ActiveMQ sends a message with this header set to "end" once all
+// messages on the queue were browsed.
+const browserHeader = "browser"
+
+// browseState is the progress of the snapshot in browse mode. Queues are
+// browsed one after another, in the configured order.
+type browseState struct {
+	// queue is the index of the queue that is browsed. It equals the number
+	// of queues once all queues were browsed.
+	queue int
+	// lastMessageID is the ID of the last message read from the queue.
+	lastMessageID string
+	// skipUntil is the ID of the last message read before the queue was
+	// subscribed to again. Messages up to and including it are skipped.
+	skipUntil string
+}
+
+// subscribeBrowser subscribes to the queue that is currently browsed. When
+// browsing is resumed, e.g. after a restart or reconnect, the messages that
+// were already read are skipped.
+func (s *Source) subscribeBrowser(ctx context.Context, conn *stomp.Conn) error {
+	if s.browse.queue >= len(s.config.Queues) {
+		return nil
+	}
+
+	queue := s.config.Queues[s.browse.queue]
+	destination := s.config.destination(queue)
+	opts := append(getSubscribeOpts(s.config), stomp.SubscribeOpt.Header(browserHeader, "true"))
+
+	// Browsing doesn't consume messages, so there is nothing to ack.
+	sub, err := conn.Subscribe(destination, stomp.AckAuto, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to browse %v: %w", destination, err)
+	}
+	sdk.Logger(ctx).Debug().
+		Str("destination", destination).
+		Str("skipUntil", s.browse.lastMessageID).
+		Msg("browsing destination")
+
+	s.browse.skipUntil = s.browse.lastMessageID
+	s.subscriptions[queue] = sub
+	go s.forward(queue, sub)
+
+	return nil
+}
+
+// browseMessage handles a message received in browse mode. It returns false
+// if the message is not read, either because it was read before or because it
+// marks the end of the queue.
+func (s *Source) browseMessage(ctx context.Context, queue string, msg *stomp.Message) (bool, error) {
+	if msg.Header.Get(browserHeader) == "end" {
+		return false, s.finishBrowsing(ctx, queue)
+	}
+
+	messageID := msg.Header.Get(frame.MessageId)
+	if s.browse.skipUntil != "" {
+		if messageID == s.browse.skipUntil {
+			s.browse.skipUntil = ""
+		}
+		return false, nil
+	}
+
+	s.browse.lastMessageID = messageID
+	return true, nil
+}
+
+// finishBrowsing unsubscribes from a queue that was browsed completely and
+// starts browsing the next one.
+func (s *Source) finishBrowsing(ctx context.Context, queue string) error {
+	sub := s.subscriptions[queue]
+	delete(s.subscriptions, queue)
+	if err := sub.Unsubscribe(); err != nil && !errors.Is(err, stomp.ErrCompletedSubscription) {
+		return fmt.Errorf("failed to unsubscribe from %v: %w", queue, err)
+	}
+
+	if s.browse.skipUntil != "" {
+		// The message read last before resuming is no longer on the queue,
+		// so we can't tell which messages were read already.
+		sdk.Logger(ctx).Warn().
+			Str("queue", queue).
+			Str("messageID", s.browse.skipUntil).
+			Msg("last browsed message not found on queue, browsing the queue again from the start")
+	} else {
+		sdk.Logger(ctx).Info().Str("queue", queue).Msg("finished browsing queue")
+		s.browse.queue++
+	}
+	s.browse.lastMessageID = ""
+
+	if s.browse.queue >= len(s.config.Queues) {
+		sdk.Logger(ctx).Info().Msg("finished browsing all queues")
+		return nil
+	}
+
+	return s.subscribeBrowser(ctx, s.conn.Conn())
+}
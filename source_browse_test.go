@@ -0,0 +1,99 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/matryer/is"
+)
+
+func TestSourceBrowseMessageSkipsReadMessages(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := &Source{
+		config: SourceConfig{Mode: modeBrowse, Queues: []string{"orders"}},
+		browse: browseState{skipUntil: "ID:2"},
+	}
+
+	newMessage := func(id string) *stomp.Message {
+		return &stomp.Message{Header: frame.NewHeader(frame.MessageId, id)}
+	}
+
+	for _, tc := range []struct {
+		id   string
+		want bool
+	}{
+		{id: "ID:1", want: false},
+		{id: "ID:2", want: false},
+		{id: "ID:3", want: true},
+		{id: "ID:4", want: true},
+	} {
+		ok, err := s.browseMessage(ctx, "orders", newMessage(tc.id))
+		is.NoErr(err)
+		is.Equal(ok, tc.want)
+	}
+	is.Equal(s.browse.lastMessageID, "ID:4")
+}
+
+func TestSourceBrowseRecordIsSnapshot(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := &Source{config: SourceConfig{Mode: modeBrowse, Operation: OperationConfig{Header: "op"}}}
+	msg := newTestMessage("", []byte("body"))
+	msg.Header.Add("op", "delete")
+
+	rec, err := s.newRecord(ctx, msg, opencdc.Position("stomp"))
+	is.NoErr(err)
+	is.Equal(rec.Operation, opencdc.OperationSnapshot)
+}
+
+func TestSourceConfigValidateBrowse(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := SourceConfig{
+		Config:          Config{URL: "localhost:61613"},
+		Queues:          []string{"orders"},
+		Mode:            modeBrowse,
+		DestinationType: destinationTypeTopic,
+	}
+	is.True(config.Validate(ctx) != nil)
+
+	config.DestinationType = destinationTypeQueue
+	is.NoErr(config.Validate(ctx))
+
+	config.MaxInFlight = 10
+	is.True(config.Validate(ctx) != nil)
+}
+
+func TestPositionBrowse(t *testing.T) {
+	is := is.New(t)
+
+	pos := Position{MessageID: "ID:1", Queue: "orders", DestinationType: "queue", Browse: true}
+	parsed, err := parseSDKPosition(pos.ToSdkPosition())
+	is.NoErr(err)
+	is.Equal(parsed, pos)
+
+	// Positions written in consume mode don't contain the browse flag.
+	pos.Browse = false
+	is.Equal(string(pos.ToSdkPosition()), `{"message_id":"ID:1","queue":"orders","destination_type":"queue"}`)
+}
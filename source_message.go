@@ -0,0 +1,319 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/goccy/go-json"
+)
+
+const (
+	decodeOpenCDCNever  = "never"
+	decodeOpenCDCDetect = "detect"
+	decodeOpenCDCAlways = "always"
+)
+
+// newRecord builds the record of a message. The position is always the STOMP
+// position of the message, so that the record can be acked. If the body can't
+// be parsed and the parse error policy isn't raw, the returned error wraps
+// errInvalidPayload.
+func (s *Source) newRecord(ctx context.Context, msg *stomp.Message, pos opencdc.Position) (opencdc.Record, error) {
+	messageID := msg.Header.Get(frame.MessageId)
+
+	metadata := make(opencdc.Metadata)
+	if s.config.MetadataRawHeaders {
+		switch s.config.MetadataHeaderEncoding {
+		case headerEncodingJSON:
+			metadata[metadataHeaders] = metadataHeadersJSON(msg)
+		default: // joined
+			metadata = metadataFromMsg(msg)
+		}
+	}
+	metadata.SetCollection(collectionFromDestination(msg.Destination))
+	setMessageMetadata(msg, metadata)
+
+	if s.config.DecodeOpenCDC == decodeOpenCDCAlways ||
+		(s.config.DecodeOpenCDC == decodeOpenCDCDetect && isJSONContentType(msg.ContentType)) {
+		rec, err := decodeOpenCDCRecord(msg.Body)
+		switch {
+		case err == nil:
+			rec.Position = pos
+			rec.Metadata = mergeDecodedMetadata(rec.Metadata, metadata)
+			if s.config.Mode == modeBrowse {
+				rec.Operation = opencdc.OperationSnapshot
+			}
+			s.setRecordKey(ctx, msg, &rec)
+			return rec, nil
+		case s.config.DecodeOpenCDC == decodeOpenCDCAlways:
+			return opencdc.Record{}, fmt.Errorf("failed to decode OpenCDC record from message %q: %w", messageID, err)
+		}
+	}
+
+	format := payloadFormat(s.config.PayloadFormat, msg.ContentType)
+	payload, err := parsePayload(format, s.config.PayloadCSVHeader, msg.Body)
+	if err != nil {
+		if s.config.PayloadParseErrorPolicy != parseErrorPolicyRaw {
+			return opencdc.Record{}, fmt.Errorf("message %q: %w", messageID, err)
+		}
+
+		sdk.Logger(ctx).Warn().Err(err).Str("messageID", messageID).Msg("reading message body as raw data")
+		payload = opencdc.RawData(msg.Body)
+	}
+
+	operation, err := s.operation(msg)
+	if err != nil {
+		return opencdc.Record{}, fmt.Errorf("failed to get operation of message %q: %w", messageID, err)
+	}
+
+	var rec opencdc.Record
+	key := opencdc.RawData(messageID)
+	switch operation {
+	case opencdc.OperationUpdate:
+		rec = sdk.Util.Source.NewRecordUpdate(pos, metadata, key, nil, payload)
+	case opencdc.OperationDelete:
+		rec = sdk.Util.Source.NewRecordDelete(pos, metadata, key, payload)
+	case opencdc.OperationSnapshot:
+		rec = sdk.Util.Source.NewRecordSnapshot(pos, metadata, key, payload)
+	default:
+		rec = sdk.Util.Source.NewRecordCreate(pos, metadata, key, payload)
+	}
+
+	s.setRecordKey(ctx, msg, &rec)
+
+	return rec, nil
+}
+
+// mergeDecodedMetadata returns the metadata of a decoded OpenCDC record, with
+// the collection and the activemq.* keys of the message that was actually
+// read. The activemq.* keys of the original message are dropped, so that e.g.
+// the message ID matches the position and the redelivery metadata is current.
+func mergeDecodedMetadata(decoded, current opencdc.Metadata) opencdc.Metadata {
+	metadata := make(opencdc.Metadata, len(decoded)+len(current))
+	for k, v := range decoded {
+		if !strings.HasPrefix(k, activemqMetadataPrefix) {
+			metadata[k] = v
+		}
+	}
+	for k, v := range current {
+		if strings.HasPrefix(k, activemqMetadataPrefix) || k == opencdc.MetadataCollection {
+			metadata[k] = v
+		}
+	}
+	metadata.SetReadAt(time.Now())
+
+	return metadata
+}
+
+// setRecordKey replaces the key of a record with the configured key. A
+// message without the configured key keeps its key, so that it doesn't stop
+// the pipeline.
+func (s *Source) setRecordKey(ctx context.Context, msg *stomp.Message, rec *opencdc.Record) {
+	customKey, err := s.recordKey(msg, *rec)
+	switch {
+	case err != nil:
+		sdk.Logger(ctx).Warn().Err(err).
+			Str("messageID", msg.Header.Get(frame.MessageId)).
+			Msg("keeping the default record key")
+	case customKey != nil:
+		rec.Key = customKey
+	}
+}
+
+// operation returns the operation of a message from the operation header.
+// Messages read in browse mode are always snapshots.
+func (s *Source) operation(msg *stomp.Message) (opencdc.Operation, error) {
+	if s.config.Mode == modeBrowse {
+		return opencdc.OperationSnapshot, nil
+	}
+	if s.config.Operation.Header == "" {
+		return opencdc.OperationCreate, nil
+	}
+
+	value, ok := msg.Header.Contains(s.config.Operation.Header)
+	if !ok {
+		return opencdc.OperationCreate, nil
+	}
+
+	if mapped, ok := s.config.Operation.Mapping[value]; ok {
+		value = mapped
+	}
+	operation, ok := parseOperation(value)
+	if !ok {
+		return 0, fmt.Errorf("unknown operation %q in header %q", value, s.config.Operation.Header)
+	}
+
+	return operation, nil
+}
+
+// parseOperation parses the name of an operation.
+func parseOperation(name string) (opencdc.Operation, bool) {
+	for _, operation := range []opencdc.Operation{
+		opencdc.OperationCreate,
+		opencdc.OperationUpdate,
+		opencdc.OperationDelete,
+		opencdc.OperationSnapshot,
+	} {
+		if name == operation.String() {
+			return operation, true
+		}
+	}
+	return 0, false
+}
+
+// recordKey returns the key of a record as configured, or nil if the message
+// ID should stay the key. It returns an error if the configured key is not
+// found in the message.
+func (s *Source) recordKey(msg *stomp.Message, rec opencdc.Record) (opencdc.Data, error) {
+	switch {
+	case s.config.KeyHeader != "":
+		value, ok := msg.Header.Contains(s.config.KeyHeader)
+		if !ok {
+			return nil, fmt.Errorf("header %q not found", s.config.KeyHeader)
+		}
+		return opencdc.RawData(value), nil
+	case s.config.KeyJSONPath != "":
+		body, ok := rec.Payload.After.(opencdc.StructuredData)
+		if !ok {
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return nil, fmt.Errorf("failed to parse body as JSON: %w", err)
+			}
+		}
+
+		value, err := lookupJSONPath(map[string]any(body), s.config.KeyJSONPath)
+		if err != nil {
+			return nil, err
+		}
+		return dataFromValue(value)
+	case s.keyTemplate != nil:
+		var sb strings.Builder
+		if err := s.keyTemplate.Execute(&sb, rec); err != nil {
+			return nil, fmt.Errorf("failed to execute key template: %w", err)
+		}
+		return opencdc.RawData(sb.String()), nil
+	default:
+		return nil, nil
+	}
+}
+
+// lookupJSONPath returns the value at a path such as $.order.items[0].id in
+// decoded JSON.
+func lookupJSONPath(value any, path string) (any, error) {
+	segments := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	segments = strings.ReplaceAll(segments, "[", ".[")
+
+	for _, segment := range strings.Split(segments, ".") {
+		if segment == "" {
+			continue
+		}
+
+		if index, ok := strings.CutPrefix(segment, "["); ok {
+			i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in JSON path %q", segment, path)
+			}
+			list, ok := value.([]any)
+			if !ok || i < 0 || i >= len(list) {
+				return nil, fmt.Errorf("index %d not found in JSON path %q", i, path)
+			}
+			value = list[i]
+			continue
+		}
+
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("field %q not found in JSON path %q", segment, path)
+		}
+		if value, ok = fields[segment]; !ok {
+			return nil, fmt.Errorf("field %q not found in JSON path %q", segment, path)
+		}
+	}
+
+	return value, nil
+}
+
+// dataFromValue converts a decoded JSON value to record data.
+func dataFromValue(value any) (opencdc.Data, error) {
+	switch v := value.(type) {
+	case string:
+		return opencdc.RawData(v), nil
+	case map[string]any:
+		return opencdc.StructuredData(v), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key: %w", err)
+		}
+		return opencdc.RawData(b), nil
+	}
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType) == contentTypeJSON
+}
+
+// decodeOpenCDCRecord decodes a record that was serialized as OpenCDC JSON,
+// e.g. by the destination in payload.mode record.
+func decodeOpenCDCRecord(body []byte) (opencdc.Record, error) {
+	// opencdc.Record.UnmarshalJSON expects the key and payload fields to be
+	// present, so we make sure the body contains all fields of a record first.
+	var probe struct {
+		Operation *opencdc.Operation `json:"operation"`
+		Key       json.RawMessage    `json:"key"`
+		Payload   *struct {
+			Before json.RawMessage `json:"before"`
+			After  json.RawMessage `json:"after"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return opencdc.Record{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if probe.Operation == nil || len(probe.Key) == 0 || probe.Payload == nil ||
+		len(probe.Payload.Before) == 0 || len(probe.Payload.After) == 0 {
+		return opencdc.Record{}, errors.New("body is not an OpenCDC record")
+	}
+
+	var rec opencdc.Record
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return opencdc.Record{}, fmt.Errorf("invalid OpenCDC record: %w", err)
+	}
+
+	rec.Key = nilIfNull(rec.Key)
+	rec.Payload.Before = nilIfNull(rec.Payload.Before)
+	rec.Payload.After = nilIfNull(rec.Payload.After)
+	if rec.Metadata == nil {
+		rec.Metadata = make(opencdc.Metadata)
+	}
+
+	return rec, nil
+}
+
+// nilIfNull returns nil for data that was decoded from a JSON null.
+func nilIfNull(data opencdc.Data) opencdc.Data {
+	if sd, ok := data.(opencdc.StructuredData); ok && sd == nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,370 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/matryer/is"
+)
+
+func newTestMessage(contentType string, body []byte) *stomp.Message {
+	header := &frame.Header{}
+	header.Add(frame.MessageId, "ID:1")
+	header.Add(frame.ContentType, contentType)
+
+	return &stomp.Message{
+		Destination: "/queue/orders",
+		ContentType: contentType,
+		Header:      header,
+		Body:        body,
+	}
+}
+
+func TestSourceNewRecordDecodeOpenCDC(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	// The metadata of the original record holds the activemq.* keys of the
+	// upstream message, which are replaced by the ones of the read message.
+	original := opencdc.Record{
+		Position:  opencdc.Position("upstream"),
+		Operation: opencdc.OperationUpdate,
+		Metadata: opencdc.Metadata{
+			"opencdc.collection":         "users",
+			"opencdc.createdAt":          "1700000000000000000",
+			"foo":                        "bar",
+			"activemq.messageId":         "ID:upstream",
+			"activemq.redelivered":       "true",
+			"activemq.deliveryCount":     "7",
+			"activemq.correlationId":     "stale",
+			"activemq.header.message-id": "ID:upstream",
+		},
+		Key: opencdc.StructuredData{"id": float64(1)},
+		Payload: opencdc.Change{
+			Before: opencdc.StructuredData{"name": "old"},
+			After:  opencdc.StructuredData{"name": "new"},
+		},
+	}
+	pos := opencdc.Position("stomp")
+
+	s := &Source{config: SourceConfig{DecodeOpenCDC: "detect", MetadataRawHeaders: true}}
+	rec, err := s.newRecord(ctx, newTestMessage("application/json", original.Bytes()), pos)
+	is.NoErr(err)
+
+	is.Equal(rec.Position, pos)
+	is.Equal(rec.Operation, original.Operation)
+	is.Equal(rec.Key, original.Key)
+	is.Equal(rec.Payload, original.Payload)
+	is.Equal(rec.Metadata["foo"], "bar")
+	is.Equal(rec.Metadata["opencdc.createdAt"], "1700000000000000000")
+	is.Equal(rec.Metadata["opencdc.collection"], "orders")
+	is.Equal(rec.Metadata["activemq.header.message-id"], "ID:1")
+	is.Equal(rec.Metadata[metadataMessageID], "ID:1")
+	is.Equal(rec.Metadata[metadataRedelivered], "false")
+	_, ok := rec.Metadata[metadataDeliveryCount]
+	is.True(!ok)
+	_, ok = rec.Metadata[metadataCorrelationID]
+	is.True(!ok)
+
+	// Browse mode reads snapshots, key.* is applied to decoded records.
+	s.config.Mode = modeBrowse
+	s.config.KeyJSONPath = "$.name"
+	rec, err = s.newRecord(ctx, newTestMessage("application/json", original.Bytes()), pos)
+	is.NoErr(err)
+	is.Equal(rec.Operation, opencdc.OperationSnapshot)
+	is.Equal(rec.Key, opencdc.RawData("new"))
+}
+
+func TestSourceConfigValidateDecodeOpenCDCOperationHeader(t *testing.T) {
+	is := is.New(t)
+
+	config := SourceConfig{
+		Config:          Config{URL: "localhost:61613"},
+		Queues:          []string{"orders"},
+		DestinationType: "queue",
+		AckMode:         "client-individual",
+		DecodeOpenCDC:   "detect",
+		Operation:       OperationConfig{Header: "op"},
+	}
+	is.True(config.Validate(context.Background()) != nil)
+}
+
+func TestSourceNewRecordDetectRaw(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := &Source{config: SourceConfig{DecodeOpenCDC: "detect"}}
+	for _, msg := range []*stomp.Message{
+		newTestMessage("text/plain", opencdc.Record{}.Bytes()),
+		newTestMessage("application/json", []byte(`{"name":"not a record"}`)),
+	} {
+		rec, err := s.newRecord(ctx, msg, opencdc.Position("stomp"))
+		is.NoErr(err)
+		is.Equal(rec.Operation, opencdc.OperationCreate)
+		is.Equal(rec.Key, opencdc.RawData("ID:1"))
+		is.Equal(rec.Payload.After, opencdc.RawData(msg.Body))
+	}
+}
+
+func TestSourceNewRecordDecodeAlways(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := &Source{config: SourceConfig{DecodeOpenCDC: "always"}}
+	_, err := s.newRecord(ctx, newTestMessage("application/json", []byte(`{"name":"not a record"}`)), opencdc.Position("stomp"))
+	is.True(err != nil)
+}
+
+func TestSourceNewRecordPayloadFormat(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name        string
+		format      string
+		contentType string
+		body        string
+		want        opencdc.Data
+	}{
+		{
+			name:        "auto json",
+			format:      "auto",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"id":1,"name":"foo"}`,
+			want:        opencdc.StructuredData{"id": float64(1), "name": "foo"},
+		},
+		{
+			name:        "auto unknown content type",
+			format:      "auto",
+			contentType: "text/plain",
+			body:        `{"id":1}`,
+			want:        opencdc.RawData(`{"id":1}`),
+		},
+		{
+			name:   "xml",
+			format: "xml",
+			body:   `<order id="1"><item>a</item><item>b</item><total>2</total></order>`,
+			want: opencdc.StructuredData{"order": map[string]any{
+				"@id":   "1",
+				"item":  []any{"a", "b"},
+				"total": "2",
+			}},
+		},
+		{
+			name:   "csv",
+			format: "csv",
+			body:   "id,name\n1,foo\n",
+			want:   opencdc.StructuredData{"id": "1", "name": "foo"},
+		},
+		{
+			name:   "invalid json read as raw",
+			format: "json",
+			body:   `[1, 2]`,
+			want:   opencdc.RawData(`[1, 2]`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := &Source{config: SourceConfig{PayloadFormat: tc.format, PayloadParseErrorPolicy: "raw"}}
+			rec, err := s.newRecord(ctx, newTestMessage(tc.contentType, []byte(tc.body)), opencdc.Position("stomp"))
+			is.NoErr(err)
+			is.Equal(rec.Payload.After, tc.want)
+		})
+	}
+}
+
+func TestSourceNewRecordParseErrorPolicy(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := &Source{config: SourceConfig{PayloadFormat: "xml", PayloadParseErrorPolicy: "error"}}
+	_, err := s.newRecord(ctx, newTestMessage("", []byte("not xml")), opencdc.Position("stomp"))
+	is.True(errors.Is(err, errInvalidPayload))
+}
+
+func TestParseCSVPayloadHeader(t *testing.T) {
+	is := is.New(t)
+
+	sd, err := parseCSVPayload([]string{"id", "name"}, []byte("1,foo"))
+	is.NoErr(err)
+	is.Equal(sd, opencdc.StructuredData{"id": "1", "name": "foo"})
+
+	_, err = parseCSVPayload([]string{"id", "name"}, []byte("1,foo\n2,bar"))
+	is.True(err != nil)
+}
+
+func TestSourceNewRecordKey(t *testing.T) {
+	ctx := context.Background()
+	body := `{"order":{"id":"o-1","items":[{"sku":"a"},{"sku":"b"}],"total":12}}`
+
+	testCases := []struct {
+		name   string
+		config SourceConfig
+		want   opencdc.Data
+	}{
+		{
+			name: "message ID",
+			want: opencdc.RawData("ID:1"),
+		},
+		{
+			name:   "header",
+			config: SourceConfig{KeyHeader: "JMSXGroupID"},
+			want:   opencdc.RawData("group-1"),
+		},
+		{
+			name:   "json path",
+			config: SourceConfig{KeyJSONPath: "$.order.id"},
+			want:   opencdc.RawData("o-1"),
+		},
+		{
+			name:   "json path with index",
+			config: SourceConfig{KeyJSONPath: "order.items[1].sku"},
+			want:   opencdc.RawData("b"),
+		},
+		{
+			name:   "json path to number",
+			config: SourceConfig{KeyJSONPath: "$.order.total"},
+			want:   opencdc.RawData("12"),
+		},
+		{
+			name:   "json path to object on parsed payload",
+			config: SourceConfig{KeyJSONPath: "$.order.items[0]", PayloadFormat: "json"},
+			want:   opencdc.StructuredData{"sku": "a"},
+		},
+		{
+			name:   "template",
+			config: SourceConfig{KeyTemplate: `{{ index .Metadata "activemq.header.JMSXGroupID" | upper }}`, MetadataRawHeaders: true},
+			want:   opencdc.RawData("GROUP-1"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := &Source{config: tc.config}
+			if tc.config.KeyTemplate != "" {
+				var err error
+				s.keyTemplate, err = parseTemplate("key", tc.config.KeyTemplate)
+				is.NoErr(err)
+			}
+
+			msg := newTestMessage("application/json", []byte(body))
+			msg.Header.Add("JMSXGroupID", "group-1")
+
+			rec, err := s.newRecord(ctx, msg, opencdc.Position("stomp"))
+			is.NoErr(err)
+			is.Equal(rec.Key, tc.want)
+		})
+	}
+}
+
+func TestSourceNewRecordKeyNotFound(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	for _, config := range []SourceConfig{
+		{KeyHeader: "JMSXGroupID"},
+		{KeyJSONPath: "$.order.customer"},
+	} {
+		s := &Source{config: config}
+		rec, err := s.newRecord(ctx, newTestMessage("", []byte(`{"order":{}}`)), opencdc.Position("stomp"))
+		is.NoErr(err)
+		is.Equal(rec.Key, opencdc.RawData("ID:1"))
+	}
+}
+
+func TestSourceNewRecordOperation(t *testing.T) {
+	ctx := context.Background()
+	config := SourceConfig{
+		Operation: OperationConfig{
+			Header:  "op",
+			Mapping: map[string]string{"I": "create", "U": "update", "D": "delete"},
+		},
+	}
+
+	testCases := []struct {
+		op         string
+		want       opencdc.Operation
+		wantBefore bool
+	}{
+		{op: "", want: opencdc.OperationCreate},
+		{op: "I", want: opencdc.OperationCreate},
+		{op: "U", want: opencdc.OperationUpdate},
+		{op: "D", want: opencdc.OperationDelete, wantBefore: true},
+		{op: "snapshot", want: opencdc.OperationSnapshot},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want.String()+"/"+tc.op, func(t *testing.T) {
+			is := is.New(t)
+
+			msg := newTestMessage("", []byte("body"))
+			if tc.op != "" {
+				msg.Header.Add("op", tc.op)
+			}
+
+			s := &Source{config: config}
+			rec, err := s.newRecord(ctx, msg, opencdc.Position("stomp"))
+			is.NoErr(err)
+			is.Equal(rec.Operation, tc.want)
+			if tc.wantBefore {
+				is.Equal(rec.Payload.Before, opencdc.RawData("body"))
+				is.Equal(rec.Payload.After, nil)
+			} else {
+				is.Equal(rec.Payload.After, opencdc.RawData("body"))
+			}
+		})
+	}
+
+	is := is.New(t)
+	msg := newTestMessage("", []byte("body"))
+	msg.Header.Add("op", "X")
+	_, err := (&Source{config: config}).newRecord(ctx, msg, opencdc.Position("stomp"))
+	is.True(err != nil)
+}
+
+func TestSourceConfigParseOperationMapping(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	var config SourceConfig
+	err := sdk.Util.ParseConfig(ctx, map[string]string{
+		"url":                 "localhost:61613",
+		"queue":               "orders",
+		"operation.header":    "op",
+		"operation.mapping.I": "create",
+		"operation.mapping.D": "delete",
+	}, &config, Connector.NewSpecification().SourceParams)
+	is.NoErr(err)
+	is.Equal(config.Operation.Header, "op")
+	is.Equal(config.Operation.Mapping, map[string]string{"I": "create", "D": "delete"})
+
+	err = sdk.Util.ParseConfig(ctx, map[string]string{
+		"url":                 "localhost:61613",
+		"queue":               "orders",
+		"operation.mapping.I": "insert",
+	}, &SourceConfig{}, Connector.NewSpecification().SourceParams)
+	is.True(err != nil)
+}
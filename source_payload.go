@@ -0,0 +1,203 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/conduitio/conduit-commons/opencdc"
+	"github.com/goccy/go-json"
+)
+
+const (
+	payloadFormatRaw  = "raw"
+	payloadFormatAuto = "auto"
+	payloadFormatJSON = "json"
+	payloadFormatXML  = "xml"
+	payloadFormatCSV  = "csv"
+)
+
+const (
+	parseErrorPolicyRaw   = "raw"
+	parseErrorPolicyNack  = "nack"
+	parseErrorPolicyError = "error"
+)
+
+// errInvalidPayload is returned when a message body can't be parsed in the
+// configured payload format.
+var errInvalidPayload = errors.New("invalid payload")
+
+// payloadFormat returns the format to parse a message body in, based on the
+// configured format and the content type of the message.
+func payloadFormat(configured, contentType string) string {
+	if configured != payloadFormatAuto {
+		return configured
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return payloadFormatJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return payloadFormatXML
+	case mediaType == "text/csv":
+		return payloadFormatCSV
+	default:
+		return payloadFormatRaw
+	}
+}
+
+// parsePayload parses a message body in the given format. Raw bodies are
+// returned as they are.
+func parsePayload(format string, csvHeader []string, body []byte) (opencdc.Data, error) {
+	var (
+		sd  opencdc.StructuredData
+		err error
+	)
+	switch format {
+	case payloadFormatJSON:
+		sd, err = parseJSONPayload(body)
+	case payloadFormatXML:
+		sd, err = parseXMLPayload(body)
+	case payloadFormatCSV:
+		sd, err = parseCSVPayload(csvHeader, body)
+	default:
+		return opencdc.RawData(body), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %v body: %w", errInvalidPayload, format, err)
+	}
+
+	return sd, nil
+}
+
+func parseJSONPayload(body []byte) (opencdc.StructuredData, error) {
+	var sd opencdc.StructuredData
+	if err := json.Unmarshal(body, &sd); err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller
+	}
+	if sd == nil {
+		return nil, errors.New("body is not a JSON object")
+	}
+	return sd, nil
+}
+
+// parseXMLPayload converts an XML document into structured data with the root
+// element as the only field. Elements that only contain text become strings,
+// other elements become maps of their child elements, with attributes
+// prefixed with @ and text stored in #text. Repeated child elements are
+// collected in a slice.
+func parseXMLPayload(body []byte) (opencdc.StructuredData, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("missing root element")
+			}
+			return nil, err //nolint:wrapcheck // wrapped by the caller
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := parseXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return opencdc.StructuredData{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func parseXMLElement(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	fields := make(map[string]any)
+	for _, attr := range start.Attr {
+		fields["@"+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err //nolint:wrapcheck // wrapped by the caller
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			value, err := parseXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+			switch existing := fields[name].(type) {
+			case nil:
+				fields[name] = value
+			case []any:
+				fields[name] = append(existing, value)
+			default:
+				fields[name] = []any{existing, value}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+			if len(fields) == 0 {
+				return content, nil
+			}
+			if content != "" {
+				fields["#text"] = content
+			}
+			return fields, nil
+		}
+	}
+}
+
+// parseCSVPayload parses a body with a single CSV row into structured data. If
+// no header is given, the first line of the body is used as the header.
+func parseCSVPayload(header []string, body []byte) (opencdc.StructuredData, error) {
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller
+	}
+
+	if len(header) == 0 {
+		if len(rows) == 0 {
+			return nil, errors.New("missing header")
+		}
+		header, rows = rows[0], rows[1:]
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+
+	row := rows[0]
+	if len(row) != len(header) {
+		return nil, fmt.Errorf("expected %d columns, got %d", len(header), len(row))
+	}
+
+	sd := make(opencdc.StructuredData, len(header))
+	for i, column := range header {
+		sd[column] = row[i]
+	}
+
+	return sd, nil
+}
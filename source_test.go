@@ -15,11 +15,18 @@
 package activemq
 
 import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/conduitio/conduit-commons/opencdc"
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/matryer/is"
+	"github.com/rs/zerolog"
 )
 
 func TestMetadataFromMsg(t *testing.T) {
@@ -40,3 +47,342 @@ func TestMetadataFromMsg(t *testing.T) {
 	is.Equal(metadata["activemq.header.key2"], "value3")
 	is.Equal(metadata["activemq.header.key3"], "value4")
 }
+
+func TestSourceConfigDestination(t *testing.T) {
+	testCases := []struct {
+		queue  string
+		config SourceConfig
+		want   string
+	}{
+		{
+			queue:  "orders",
+			config: SourceConfig{DestinationType: "queue"},
+			want:   "/queue/orders",
+		},
+		{
+			queue:  "/queue/orders",
+			config: SourceConfig{DestinationType: "queue"},
+			want:   "/queue/orders",
+		},
+		{
+			queue:  "orders",
+			config: SourceConfig{DestinationType: "topic"},
+			want:   "/topic/orders",
+		},
+		{
+			queue:  "orders",
+			config: SourceConfig{DestinationType: "virtualTopic", ConsumerName: "A"},
+			want:   "/queue/Consumer.A.VirtualTopic.orders",
+		},
+		{
+			queue:  "VirtualTopic.orders",
+			config: SourceConfig{DestinationType: "virtualTopic", ConsumerName: "A"},
+			want:   "/queue/Consumer.A.VirtualTopic.orders",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tc.config.destination(tc.queue), tc.want)
+		})
+	}
+}
+
+func TestSourceConfigValidateDurableTopic(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := SourceConfig{
+		Config:           Config{URL: "localhost:61613"},
+		Queues:           []string{"orders"},
+		DestinationType:  "topic",
+		SubscriptionName: "orders-sub",
+	}
+	is.True(config.Validate(ctx) != nil)
+
+	config.ClientID = "conduit"
+	is.NoErr(config.Validate(ctx))
+}
+
+func TestCollectionFromDestination(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(collectionFromDestination("/queue/orders.eu"), "orders.eu")
+	is.Equal(collectionFromDestination("/topic/orders.eu"), "orders.eu")
+	is.Equal(collectionFromDestination("orders.eu"), "orders.eu")
+}
+
+func TestSetMessageMetadata(t *testing.T) {
+	is := is.New(t)
+
+	header := &frame.Header{}
+	header.Add("message-id", "ID:1")
+	header.Add("timestamp", "1700000000123")
+	header.Add("expires", "1700000060000")
+	header.Add("priority", "4")
+	header.Add("redelivered", "true")
+	header.Add("JMSXDeliveryCount", "3")
+	header.Add("correlation-id", "corr-1")
+	header.Add("reply-to", "/queue/replies")
+	header.Add("type", "order")
+
+	metadata := make(opencdc.Metadata)
+	setMessageMetadata(&stomp.Message{Destination: "/queue/orders", Header: header}, metadata)
+
+	createdAt, err := metadata.GetCreatedAt()
+	is.NoErr(err)
+	is.Equal(createdAt, time.UnixMilli(1700000000123))
+
+	is.Equal(metadata, opencdc.Metadata{
+		"opencdc.createdAt":   metadata["opencdc.createdAt"],
+		metadataDestination:   "/queue/orders",
+		metadataMessageID:     "ID:1",
+		metadataExpires:       "2023-11-14T22:14:20Z",
+		metadataPriority:      "4",
+		metadataRedelivered:   "true",
+		metadataDeliveryCount: "3",
+		metadataCorrelationID: "corr-1",
+		metadataReplyTo:       "/queue/replies",
+		metadataType:          "order",
+	})
+
+	metadata = make(opencdc.Metadata)
+	setMessageMetadata(&stomp.Message{Header: &frame.Header{}}, metadata)
+	is.Equal(metadata, opencdc.Metadata{metadataRedelivered: "false"})
+}
+
+func TestSourceConfigValidateMaxInFlight(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := SourceConfig{
+		Config:          Config{URL: "localhost:61613"},
+		Queues:          []string{"orders"},
+		DestinationType: "queue",
+		MaxInFlight:     10,
+		PrefetchSize:    100,
+	}
+	is.True(config.Validate(ctx) != nil)
+
+	config.PrefetchSize = 0
+	is.NoErr(config.Validate(ctx))
+
+	// The prefetch size defaults to maxInFlight.
+	f := frame.New(frame.SUBSCRIBE)
+	for _, opt := range getSubscribeOpts(config) {
+		is.NoErr(opt(f))
+	}
+	is.Equal(f.Header.Get("activemq.prefetchSize"), "10")
+}
+
+func TestSourceConfigValidateAckMode(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	config := SourceConfig{
+		Config:          Config{URL: "localhost:61613"},
+		Queues:          []string{"orders"},
+		DestinationType: "queue",
+		AckMode:         "client",
+		AckTimeout:      time.Minute,
+	}
+	is.True(config.Validate(ctx) != nil)
+
+	config.AckTimeout = 0
+	is.NoErr(config.Validate(ctx))
+
+	config.AckMode = "auto"
+	config.MaxInFlight = 10
+	is.True(config.Validate(ctx) != nil)
+}
+
+func TestSourceConfigValidateNackVersions(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	// STOMP 1.0 has no NACK frame.
+	config := SourceConfig{
+		Config:          Config{URL: "localhost:61613", AcceptVersions: []string{"1.0"}},
+		Queues:          []string{"orders"},
+		DestinationType: "queue",
+		AckMode:         "client-individual",
+		AckTimeout:      time.Minute,
+	}
+	is.True(config.Validate(ctx) != nil)
+
+	config.AckTimeout = 0
+	config.PayloadParseErrorPolicy = "nack"
+	is.True(config.Validate(ctx) != nil)
+
+	config.AcceptVersions = []string{"1.0", "1.1"}
+	is.NoErr(config.Validate(ctx))
+
+	config.AcceptVersions = nil
+	is.NoErr(config.Validate(ctx))
+}
+
+// fakeBroker is a minimal STOMP broker. On every connection it delivers one
+// message to each subscription and reports the acks it receives. The
+// in-memory server of go-stomp doesn't handle client-individual acks
+// correctly, so it can't be used to test acks.
+type fakeBroker struct {
+	addr string
+	// conns receives every accepted connection, so that tests can drop it.
+	conns chan net.Conn
+	acks  chan string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	b := &fakeBroker{
+		addr:  l.Addr().String(),
+		conns: make(chan net.Conn, 10),
+		acks:  make(chan string, 10),
+	}
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.conns <- conn
+			go b.serve(conn, n)
+		}
+	}()
+
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn, n int) {
+	defer conn.Close()
+
+	reader, writer := frame.NewReader(conn), frame.NewWriter(conn)
+	for {
+		f, err := reader.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			continue // heart-beat
+		}
+
+		switch f.Command {
+		case frame.CONNECT, frame.STOMP:
+			err = writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+		case frame.SUBSCRIBE:
+			messageID := "ID:" + strconv.Itoa(n)
+			msg := frame.New(frame.MESSAGE,
+				frame.Destination, f.Header.Get(frame.Destination),
+				frame.Subscription, f.Header.Get(frame.Id),
+				frame.MessageId, messageID,
+				frame.Ack, messageID,
+			)
+			msg.Body = []byte("message " + strconv.Itoa(n))
+			err = writer.Write(msg)
+		case frame.ACK:
+			b.acks <- f.Header.Get(frame.Id)
+		}
+		if receipt, ok := f.Header.Contains(frame.Receipt); ok && err == nil {
+			err = writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+		}
+		if err != nil || f.Command == frame.DISCONNECT {
+			return
+		}
+	}
+}
+
+func TestSourceReconnect(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	broker := newFakeBroker(t)
+
+	s := &Source{
+		config: SourceConfig{
+			Config: Config{
+				URL: broker.addr,
+				Reconnect: ReconnectConfig{
+					Enabled:        true,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     time.Millisecond,
+					BackoffFactor:  2,
+				},
+			},
+			Queues:          []string{"orders"},
+			DestinationType: destinationTypeQueue,
+			AckMode:         ackModeClientIndividual,
+		},
+		subscriptions: make(map[string]*stomp.Subscription),
+		received:      make(chan receivedMessage),
+		done:          make(chan struct{}),
+	}
+	is.NoErr(s.Open(ctx, nil))
+	defer func() { is.NoErr(s.Teardown(ctx)) }()
+
+	readAndAck := func(want string) {
+		readCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		rec, err := s.Read(readCtx)
+		is.NoErr(err)
+		is.Equal(rec.Payload.After, opencdc.RawData(want))
+		is.NoErr(s.Ack(ctx, rec.Position))
+	}
+
+	readAndAck("message 1")
+	is.Equal(<-broker.acks, "ID:1")
+
+	// Dropping the connection makes the source reconnect and subscribe again,
+	// after which reading and acking resume on the new connection.
+	is.NoErr((<-broker.conns).Close())
+
+	readAndAck("message 2")
+	is.Equal(<-broker.acks, "ID:2")
+}
+
+// logWriter sends written log lines to a channel, lines are dropped while the
+// channel is full.
+type logWriter chan string
+
+func (w logWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestSourceLogInFlight(t *testing.T) {
+	is := is.New(t)
+
+	logs := make(logWriter, 10)
+	logger := zerolog.New(logs)
+	ctx := logger.WithContext(context.Background())
+
+	s := &Source{
+		config:   SourceConfig{MaxInFlight: 10, InFlightLogInterval: time.Millisecond},
+		inFlight: newInFlight(10, false),
+		done:     make(chan struct{}),
+	}
+	s.inFlight.add("ID:1", &delivery{msg: newTestMessage("", nil), readAt: time.Now().Add(-time.Minute)})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.logInFlight(ctx)
+		close(stopped)
+	}()
+
+	line := <-logs
+	close(s.done)
+	<-stopped
+
+	is.True(strings.Contains(line, `"level":"info"`))
+	is.True(strings.Contains(line, `"inFlight":1`))
+	is.True(strings.Contains(line, `"maxInFlight":10`))
+	is.True(strings.Contains(line, `"message":"in-flight messages"`))
+}
@@ -17,74 +17,105 @@ package activemq
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
-	"os"
 
 	sdk "github.com/conduitio/conduit-connector-sdk"
 	"github.com/go-stomp/stomp/v3"
 )
 
-func connectSource(ctx context.Context, config SourceConfig) (*stomp.Conn, error) {
-	return connect(ctx, config.Config, config.ClientID)
+func connectSource(ctx context.Context, config SourceConfig, broker brokerAddr) (*stomp.Conn, error) {
+	return connect(ctx, config.Config, config.ClientID, broker)
 }
 
-func connectDestination(ctx context.Context, config DestinationConfig) (*stomp.Conn, error) {
+func connectDestination(ctx context.Context, config DestinationConfig, broker brokerAddr) (*stomp.Conn, error) {
 	// According to Activemq Classic docs, the client-id is used in combination
 	// with the activemq.subscriptionName to denote a durable subscriber. Therefore,
 	// it only makes sense to set the client-id when connecting as a source.
-	return connect(ctx, config.Config, "")
+	var opts []func(*stomp.Conn) error
+	if config.Receipt.Enabled {
+		opts = append(opts, stomp.ConnOpt.RcvReceiptTimeout(config.Receipt.Timeout))
+	}
+
+	return connect(ctx, config.Config, "", broker, opts...)
 }
 
-func connect(ctx context.Context, config Config, clientID string) (*stomp.Conn, error) {
+func connect(
+	ctx context.Context,
+	config Config,
+	clientID string,
+	broker brokerAddr,
+	opts ...func(*stomp.Conn) error,
+) (*stomp.Conn, error) {
+	// The password is read on every dial, so that reconnects use a rotated password.
+	password, err := config.password()
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := config.stompVersions()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := config.connectHeaders()
+	if err != nil {
+		return nil, err
+	}
+
 	connOpts := []func(*stomp.Conn) error{
-		stomp.ConnOpt.Login(config.User, config.Password),
+		stomp.ConnOpt.Login(config.User, password),
 		stomp.ConnOpt.HeartBeat(config.SendTimeoutHeartbeat, config.RecvTimeoutHeartbeat),
 	}
 	if clientID != "" {
 		opt := stomp.ConnOpt.Header("client-id", clientID)
 		connOpts = append(connOpts, opt)
 	}
+	if config.VirtualHost != "" {
+		connOpts = append(connOpts, stomp.ConnOpt.Host(config.VirtualHost))
+	}
+	if len(versions) > 0 {
+		connOpts = append(connOpts, stomp.ConnOpt.AcceptVersion(versions...))
+	}
+	for i := range headers.Len() {
+		connOpts = append(connOpts, stomp.ConnOpt.Header(headers.GetAt(i)))
+	}
+	connOpts = append(connOpts, opts...)
+
+	if broker.useWebSocket() {
+		wsConn, err := dialWebSocket(ctx, config, broker)
+		if err != nil {
+			return nil, err
+		}
+		sdk.Logger(ctx).Debug().Stringer("broker", broker).Msg("WebSocket connection established")
 
-	if !config.TLS.Enabled {
-		conn, err := stomp.Dial("tcp", config.URL, connOpts...)
+		conn, err := stomp.Connect(wsConn, connOpts...)
 		if err != nil {
+			_ = wsConn.Close()
 			return nil, fmt.Errorf("failed to connect to ActiveMQ: %w", err)
 		}
-		sdk.Logger(ctx).Debug().Msg("opened connection to ActiveMQ")
+		sdk.Logger(ctx).Debug().Stringer("broker", broker).Msg("STOMP connection over WebSocket established")
 
 		return conn, nil
 	}
 
-	sdk.Logger(ctx).Debug().Msg("using TLS to connect to ActiveMQ")
-
-	cert, err := tls.LoadX509KeyPair(config.TLS.ClientCertPath, config.TLS.ClientKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load client key pair: %w", err)
-	}
-	sdk.Logger(ctx).Debug().Msg("loaded client key pair")
+	if !config.TLS.Enabled && !broker.useTLS() {
+		conn, err := stomp.Dial("tcp", broker.host, connOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ActiveMQ: %w", err)
+		}
+		sdk.Logger(ctx).Debug().Stringer("broker", broker).Msg("opened connection to ActiveMQ")
 
-	caCert, err := os.ReadFile(config.TLS.CaCertPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load CA cert: %w", err)
+		return conn, nil
 	}
-	sdk.Logger(ctx).Debug().Msg("loaded CA cert")
-
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		MaxVersion: tls.VersionTLS13,
 
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
+	sdk.Logger(ctx).Debug().Msg("using TLS to connect to ActiveMQ")
 
-		InsecureSkipVerify: config.TLS.InsecureSkipVerify, // #nosec G402
+	tlsConfig, err := config.TLS.clientConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	netConn, err := tls.Dial("tcp", config.URL, tlsConfig)
+	netConn, err := tls.Dial("tcp", broker.host, tlsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to ActiveMQ using tls: %w", err)
 	}
@@ -94,25 +125,23 @@ func connect(ctx context.Context, config Config, clientID string) (*stomp.Conn,
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to ActiveMQ: %w", err)
 	}
-	sdk.Logger(ctx).Debug().Msg("STOMP connection using tls established")
+	sdk.Logger(ctx).Debug().Stringer("broker", broker).Msg("STOMP connection using tls established")
 
 	return conn, nil
 }
 
-func teardown(ctx context.Context, subs *stomp.Subscription, conn *stomp.Conn) error {
-	if subs != nil {
-		err := subs.Unsubscribe()
+func teardown(ctx context.Context, subs map[string]*stomp.Subscription, conn *connManager) error {
+	for queue, sub := range subs {
+		err := sub.Unsubscribe()
 		if errors.Is(err, stomp.ErrCompletedSubscription) {
-			sdk.Logger(ctx).Debug().Msg("subscription already unsubscribed")
+			sdk.Logger(ctx).Debug().Str("queue", queue).Msg("subscription already unsubscribed")
 		} else if err != nil {
-			return fmt.Errorf("failed to unsubscribe: %w", err)
+			return fmt.Errorf("failed to unsubscribe from %v: %w", queue, err)
 		}
 	}
 
 	if conn != nil {
-		if err := conn.Disconnect(); err != nil {
-			return fmt.Errorf("failed to disconnect from ActiveMQ: %w", err)
-		}
+		return conn.Close()
 	}
 
 	return nil
@@ -0,0 +1,185 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Validate validates the TLS configuration without loading any files.
+func (c TLSConfig) Validate() error {
+	var errs []error
+
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		errs = append(errs, errors.New("tls.clientCertPath and tls.clientKeyPath must be set together"))
+	}
+	if c.ClientCertPath != "" && c.KeystorePath != "" {
+		errs = append(errs, errors.New("tls.clientCertPath and tls.keystorePath can't be used together"))
+	}
+	if c.CaCertPath != "" && c.TruststorePath != "" {
+		errs = append(errs, errors.New("tls.caCertPath and tls.truststorePath can't be used together"))
+	}
+	if _, err := cipherSuiteIDs(c.CipherSuites); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// clientConfig builds the TLS configuration used to dial the broker. Client
+// certificates are optional, and the system roots are used if no CA is
+// configured.
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	cipherSuites, err := cipherSuiteIDs(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tlsVersions[c.MinVersion],
+		MaxVersion:   tls.VersionTLS13,
+		CipherSuites: cipherSuites,
+		ServerName:   c.ServerName,
+
+		InsecureSkipVerify: c.InsecureSkipVerify, // #nosec G402
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	switch {
+	case c.ClientCertPath != "":
+		cert, err := tls.LoadX509KeyPair(c.ClientCertPath, c.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case c.KeystorePath != "":
+		cert, err := loadKeystore(c.KeystorePath, c.KeystorePassword)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch {
+	case c.CaCertPath != "":
+		caCert, err := os.ReadFile(c.CaCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA cert: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid PEM certificates found in CA cert %v", c.CaCertPath)
+		}
+	case c.TruststorePath != "":
+		certs, err := loadTruststore(c.TruststorePath, c.TruststorePassword)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		for _, cert := range certs {
+			tlsConfig.RootCAs.AddCert(cert)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadKeystore loads the client certificate and key from a PKCS#12 keystore.
+func loadKeystore(path, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decode keystore %v: %w", path, err)
+	}
+
+	chain := [][]byte{cert.Raw}
+	for _, caCert := range caCerts {
+		chain = append(chain, caCert.Raw)
+	}
+
+	return tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+// loadTruststore loads the trusted certificates from a PKCS#12 truststore. A
+// keystore, e.g. the one of the broker, is accepted as well, in which case its
+// certificate chain is trusted.
+func loadTruststore(path, password string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read truststore: %w", err)
+	}
+
+	certs, err := pkcs12.DecodeTrustStore(data, password)
+	if err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+
+	_, cert, caCerts, chainErr := pkcs12.DecodeChain(data, password)
+	if chainErr != nil {
+		return nil, fmt.Errorf("failed to decode truststore %v: %w", path, errors.Join(err, chainErr))
+	}
+
+	return append([]*x509.Certificate{cert}, caCerts...), nil
+}
+
+// cipherSuiteIDs returns the IDs of cipher suites given by their names, such
+// as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. It returns nil if no names are
+// given, so that the Go defaults are used.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,91 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTLSClientConfigServerOnly(t *testing.T) {
+	is := is.New(t)
+
+	tlsConfig, err := TLSConfig{Enabled: true, MinVersion: "1.3", ServerName: "broker.example.com"}.clientConfig()
+	is.NoErr(err)
+	is.Equal(len(tlsConfig.Certificates), 0)
+	is.Equal(tlsConfig.RootCAs, nil) // system roots
+	is.Equal(tlsConfig.MinVersion, uint16(tls.VersionTLS13))
+	is.Equal(tlsConfig.ServerName, "broker.example.com")
+}
+
+func TestTLSClientConfigPEM(t *testing.T) {
+	is := is.New(t)
+
+	tlsConfig, err := TLSConfig{
+		Enabled:        true,
+		ClientCertPath: "./test/certs/client_cert.pem",
+		ClientKeyPath:  "./test/certs/client_key.pem",
+		CaCertPath:     "./test/certs/broker.pem",
+		CipherSuites:   []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}.clientConfig()
+	is.NoErr(err)
+	is.Equal(len(tlsConfig.Certificates), 1)
+	is.True(tlsConfig.RootCAs != nil)
+	is.Equal(tlsConfig.MinVersion, uint16(tls.VersionTLS12))
+	is.Equal(tlsConfig.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+}
+
+func TestTLSClientConfigPKCS12(t *testing.T) {
+	is := is.New(t)
+
+	tlsConfig, err := TLSConfig{
+		Enabled:            true,
+		KeystorePath:       "./test/certs/client.p12",
+		KeystorePassword:   "password",
+		TruststorePath:     "./test/certs/broker.p12",
+		TruststorePassword: "password",
+	}.clientConfig()
+	is.NoErr(err)
+	is.Equal(len(tlsConfig.Certificates), 1)
+	is.Equal(tlsConfig.Certificates[0].Leaf.Subject.CommonName, "client")
+	is.True(tlsConfig.RootCAs != nil)
+
+	_, err = TLSConfig{KeystorePath: "./test/certs/client.p12", KeystorePassword: "wrong"}.clientConfig()
+	is.True(err != nil)
+}
+
+func TestTLSClientConfigMalformedCA(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	is.NoErr(os.WriteFile(path, []byte("not a certificate"), 0o600))
+
+	_, err := TLSConfig{Enabled: true, CaCertPath: path}.clientConfig()
+	is.True(err != nil)
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	is := is.New(t)
+
+	is.NoErr(TLSConfig{}.Validate())
+	is.True(TLSConfig{ClientCertPath: "cert.pem"}.Validate() != nil)
+	is.True(TLSConfig{ClientCertPath: "cert.pem", ClientKeyPath: "key.pem", KeystorePath: "client.p12"}.Validate() != nil)
+	is.True(TLSConfig{CaCertPath: "ca.pem", TruststorePath: "trust.p12"}.Validate() != nil)
+	is.True(TLSConfig{CipherSuites: []string{"TLS_UNKNOWN"}}.Validate() != nil)
+}
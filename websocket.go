@@ -0,0 +1,200 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/gorilla/websocket"
+)
+
+// webSocketSubprotocols returns the STOMP versions offered during the
+// WebSocket handshake, the accepted versions or all versions if none are
+// configured. See https://stomp.github.io/stomp-specification-1.2.html.
+func webSocketSubprotocols(versions []stomp.Version) []string {
+	if len(versions) == 0 {
+		versions = []stomp.Version{stomp.V12, stomp.V11, stomp.V10}
+	}
+
+	subprotocols := make([]string, len(versions))
+	for i, version := range versions {
+		subprotocols[i] = "v" + strings.ReplaceAll(string(version), ".", "") + ".stomp"
+	}
+	return subprotocols
+}
+
+// dialWebSocket opens a WebSocket connection to a broker with a ws or wss
+// scheme. TLS is used for wss, or for ws if TLS is enabled in the config.
+func dialWebSocket(ctx context.Context, config Config, broker brokerAddr) (io.ReadWriteCloser, error) {
+	versions, err := config.stompVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		Subprotocols:     webSocketSubprotocols(versions),
+	}
+
+	scheme := broker.scheme
+	if config.TLS.Enabled || broker.useTLS() {
+		tlsConfig, err := config.TLS.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		dialer.TLSClientConfig = tlsConfig
+		scheme = "wss"
+	}
+
+	path := broker.path
+	if path == "" {
+		path = "/"
+	}
+
+	//nolint:bodyclose // the response body is closed by the websocket package
+	conn, _, err := dialer.DialContext(ctx, scheme+"://"+broker.host+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WebSocket connection: %w", err)
+	}
+
+	return &webSocketConn{conn: conn}, nil
+}
+
+// webSocketConn adapts a WebSocket connection to the byte stream expected by
+// the STOMP client. The broker expects each WebSocket message to contain
+// exactly one STOMP frame or heart-beat, so written bytes are buffered until a
+// frame is complete. Frames are sent as text messages, unless they are not
+// valid UTF-8, e.g. because of a binary body, in which case they are sent as
+// binary messages.
+type webSocketConn struct {
+	conn *websocket.Conn
+
+	// reader reads the current message, it is nil if the next message needs
+	// to be read.
+	reader io.Reader
+	// pending holds the written bytes of a frame that is not complete yet.
+	pending []byte
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.conn.NextReader()
+			if err != nil {
+				return 0, err //nolint:wrapcheck // read by the STOMP client
+			}
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+
+	for {
+		n := frameLength(c.pending)
+		if n == 0 {
+			return len(p), nil
+		}
+
+		messageType := websocket.TextMessage
+		if !utf8.Valid(c.pending[:n]) {
+			messageType = websocket.BinaryMessage
+		}
+		if err := c.conn.WriteMessage(messageType, c.pending[:n]); err != nil {
+			return 0, err //nolint:wrapcheck // read by the STOMP client
+		}
+		c.pending = append(c.pending[:0], c.pending[n:]...)
+	}
+}
+
+func (c *webSocketConn) Close() error {
+	return c.conn.Close() //nolint:wrapcheck // read by the STOMP client
+}
+
+// frameLength returns the length of the STOMP frame or heart-beat at the
+// start of b, or 0 if it is not complete yet. It only needs to understand the
+// frames written by the STOMP client, which always end lines with LF.
+func frameLength(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	if b[0] == '\n' {
+		// heart-beat
+		return 1
+	}
+
+	headerEnd := bytes.Index(b, []byte("\n\n"))
+	if headerEnd < 0 {
+		return 0
+	}
+	bodyStart := headerEnd + 2
+
+	if length, ok := frameContentLength(b[:headerEnd]); ok {
+		// The body can contain null bytes, the frame ends after the body and
+		// the terminating null byte.
+		end := bodyStart + length + 1
+		if len(b) < end {
+			return 0
+		}
+		return end
+	}
+
+	end := bytes.IndexByte(b[bodyStart:], 0)
+	if end < 0 {
+		return 0
+	}
+	return bodyStart + end + 1
+}
+
+// frameContentLength returns the value of the content-length header in the
+// command and header lines of a frame.
+func frameContentLength(head []byte) (int, bool) {
+	lines := bytes.Split(head, []byte("\n"))
+	for _, line := range lines[1:] {
+		value, ok := bytes.CutPrefix(line, []byte("content-length:"))
+		if !ok {
+			continue
+		}
+
+		length, err := strconv.Atoi(string(value))
+		if err != nil || length < 0 {
+			return 0, false
+		}
+		return length, true
+	}
+
+	return 0, false
+}
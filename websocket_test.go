@@ -0,0 +1,124 @@
+// Copyright © 2024 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activemq
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/gorilla/websocket"
+	"github.com/matryer/is"
+)
+
+func TestFrameLength(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(frameLength([]byte("\n")), 1)
+	is.Equal(frameLength([]byte("SEND\ndestination:/queue/a\n")), 0)
+	is.Equal(frameLength([]byte("SEND\ndestination:/queue/a\n\nbody")), 0)
+	is.Equal(frameLength([]byte("SEND\ndestination:/queue/a\n\nbody\x00SEND")), 32)
+
+	// The content length allows null bytes in the body.
+	frame := []byte("SEND\ncontent-length:3\n\na\x00b\x00")
+	is.Equal(frameLength(frame[:len(frame)-1]), 0)
+	is.Equal(frameLength(frame), len(frame))
+}
+
+func TestWebSocketSubprotocols(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(webSocketSubprotocols(nil), []string{"v12.stomp", "v11.stomp", "v10.stomp"})
+	is.Equal(webSocketSubprotocols([]stomp.Version{stomp.V11}), []string{"v11.stomp"})
+}
+
+func TestConnectWebSocket(t *testing.T) {
+	type received struct {
+		messageType int
+		data        string
+	}
+
+	testCases := []struct {
+		name        string
+		body        []byte
+		messageType int
+	}{
+		{
+			name:        "text",
+			body:        bytes.Repeat([]byte("x"), 10_000),
+			messageType: websocket.TextMessage,
+		},
+		{
+			name:        "binary",
+			body:        []byte{0xff, 0xfe, 0x00, 0x80},
+			messageType: websocket.BinaryMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			messages := make(chan received, 1)
+			upgrader := websocket.Upgrader{Subprotocols: webSocketSubprotocols(nil)}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/stomp" {
+					http.NotFound(w, r)
+					return
+				}
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				for {
+					messageType, msg, err := conn.ReadMessage()
+					if err != nil {
+						return
+					}
+
+					// Every message has to contain a single complete frame.
+					command, _, _ := strings.Cut(string(msg), "\n")
+					switch command {
+					case "STOMP", "CONNECT":
+						_ = conn.WriteMessage(websocket.TextMessage, []byte("CONNECTED\nversion:1.1\n\n\x00"))
+					case "SEND":
+						messages <- received{messageType: messageType, data: string(msg)}
+					}
+				}
+			}))
+			defer server.Close()
+
+			broker, err := parseBrokerAddr("ws://" + strings.TrimPrefix(server.URL, "http://") + "/stomp")
+			is.NoErr(err)
+
+			conn, err := connect(ctx, Config{AcceptVersions: []string{"1.1"}}, "", broker)
+			is.NoErr(err)
+			defer conn.MustDisconnect() //nolint:errcheck // closing the test connection
+
+			is.NoErr(conn.Send("/queue/test", "application/octet-stream", tc.body))
+			msg := <-messages
+			is.Equal(msg.messageType, tc.messageType)
+			is.True(strings.HasPrefix(msg.data, "SEND\n"))
+			is.True(strings.HasSuffix(msg.data, string(tc.body)+"\x00"))
+		})
+	}
+}